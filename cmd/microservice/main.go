@@ -0,0 +1,239 @@
+// +build !test
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/alerts"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/auth"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/connectors"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/encryption"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/handlers"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/validator"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	logger *logging.Logger
+)
+
+// routes - the /api/v1/object(s) routes serve the default customer resource,
+// the {resource} routes are registered last so that they don't shadow them
+var routes = []struct {
+	path   string
+	method string
+	crudl  string
+}{
+	{"/api/v1/object", "POST", "DBInsert"},
+	{"/api/v1/object", "PUT", "DBUpdate"},
+	{"/api/v1/object/{id}", "GET", "DBGet"},
+	{"/api/v1/object/{id}", "DELETE", "DBDelete"},
+	{"/api/v1/object/{id}/restore", "POST", "DBRestore"},
+	{"/api/v1/object/{id}/history", "GET", "DBHistory"},
+	{"/api/v1/objects", "GET", "DBList"},
+	{"/api/v1/objects/bulk", "POST", "DBBulkInsert"},
+	{"/api/v1/objects/bulk", "PUT", "DBBulkUpsert"},
+	{"/api/v1/objects/bulk/delete", "POST", "DBBulkDelete"},
+	{"/api/v1/objects/{from}/{to}", "GET", "DBList"},
+	{"/api/v1/objects/{from}/{to}/{search}", "GET", "DBList"},
+	{"/api/v1/{resource}", "POST", "DBInsert"},
+	{"/api/v1/{resource}", "PUT", "DBUpdate"},
+	{"/api/v1/{resource}/bulk", "POST", "DBBulkInsert"},
+	{"/api/v1/{resource}/bulk", "PUT", "DBBulkUpsert"},
+	{"/api/v1/{resource}/bulk/delete", "POST", "DBBulkDelete"},
+	{"/api/v1/{resource}/list", "GET", "DBList"},
+	{"/api/v1/{resource}/list/{from}/{to}", "GET", "DBList"},
+	{"/api/v1/{resource}/list/{from}/{to}/{search}", "GET", "DBList"},
+	{"/api/v1/{resource}/{id}", "GET", "DBGet"},
+	{"/api/v1/{resource}/{id}", "DELETE", "DBDelete"},
+	{"/api/v1/{resource}/{id}/restore", "POST", "DBRestore"},
+	{"/api/v1/{resource}/{id}/history", "GET", "DBHistory"},
+}
+
+// startHttpServer - registers all the routes and serves on the server port
+func startHttpServer(conn connectors.Clients, verifier *auth.Verifier) *http.Server {
+	srv := &http.Server{Addr: ":" + conn.Config().Server.Port}
+
+	r := mux.NewRouter()
+
+	// crudl endpoints, all of them need a bearer token
+	authenticate := handlers.Authenticate(verifier, conn)
+	for _, route := range routes {
+		crudl := route.crudl
+		r.Handle(route.path, authenticate(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			handlers.MiddlewareHandler(w, req, conn, crudl)
+		}))).Methods(route.method)
+	}
+
+	// system endpoints
+	r.HandleFunc("/api/v2/sys/info/isalive", func(w http.ResponseWriter, req *http.Request) {
+		handlers.IsAlive(w, req, conn)
+	}).Methods("GET")
+	r.HandleFunc("/api/v2/sys/info/isready", func(w http.ResponseWriter, req *http.Request) {
+		handlers.IsReady(w, req, conn)
+	}).Methods("GET")
+	r.Handle("/api/v2/sys/config", authenticate(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handlers.EffectiveConfig(w, req, conn)
+	}))).Methods("GET")
+	r.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	r.PathPrefix("/api/v2/api-docs/").Handler(http.StripPrefix("/api/v2/api-docs/", http.FileServer(http.Dir("./swaggerui/"))))
+
+	http.Handle("/", r)
+
+	go func() {
+		logger.Info(fmt.Sprintf("Starting server on port %s", conn.Config().Server.Port))
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error(fmt.Sprintf("Httpserver: ListenAndServe() error: %v", err))
+			os.Exit(1)
+		}
+	}()
+	return srv
+}
+
+// waitForShutdown - blocks until SIGTERM/SIGINT, stops accepting new requests, drains in-flight requests and
+// stops the background jobs (a running purge or webhook delivery is waited for) within the deadline and then
+// closes all backend connections
+func waitForShutdown(srv *http.Server, conn connectors.Clients, jobs context.CancelFunc, running *sync.WaitGroup) {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-stop
+	logger.Info(fmt.Sprintf("Received signal %v : shutting down", sig))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(conn.Config().Server.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error(fmt.Sprintf("Httpserver: Shutdown() error: %v", err))
+	}
+	jobs()
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("Background jobs still running at the shutdown deadline")
+	}
+	if err := conn.Close(); err != nil {
+		logger.Error(fmt.Sprintf("Closing connections: %v", err))
+	}
+	logger.Info("Shutdown complete")
+}
+
+func main() {
+
+	// json log lines, the level is set once the config is loaded
+	logger = logging.New(os.Stdout, "info")
+
+	// the settings of CONFIG_FILE (yaml or json) overridden by the envars, all the invalid settings are logged
+	file := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(file)
+	if err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			logger.Error(fmt.Sprintf("Config %s", line))
+		}
+		os.Exit(1)
+	}
+	logger.SetLevel(cfg.LogLevel)
+
+	// register the additional resources (the customer resource is always available)
+	if cfg.Resources != "" {
+		if err = connectors.LoadResources(cfg.Resources); err != nil {
+			logger.Error(fmt.Sprintf("Loading resources %v", err))
+			os.Exit(1)
+		}
+	}
+	for _, res := range connectors.Resources() {
+		if res.Schema == "" {
+			continue
+		}
+		if err = validator.RegisterSchema(res.Name, res.Schema); err != nil {
+			logger.Error(fmt.Sprintf("Loading json schema for %s %v", res.Name, err))
+			os.Exit(1)
+		}
+	}
+
+	// the roles allowed to run each crudl operation, without a policy any authenticated caller is allowed
+	if cfg.Auth.PolicyFile != "" {
+		if err = auth.LoadPolicy(cfg.Auth.PolicyFile); err != nil {
+			logger.Error(fmt.Sprintf("Loading the access policy %v", err))
+			os.Exit(1)
+		}
+	}
+
+	// the bearer token keys, auth.disabled (AUTH_DISABLED=true) serves the crudl endpoints without authentication
+	verifier, err := auth.VerifierFromConfig(cfg.Auth)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Loading the token keys %v", err))
+		os.Exit(1)
+	}
+	if verifier == nil {
+		logger.Warn("AUTH_DISABLED is set, the crudl endpoints are not authenticated")
+	}
+
+	// the customer pii fields are encrypted when the encryption key file or key is set
+	fields, err := encryption.FromConfig(cfg.Encryption)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Loading the encryption keys %v", err))
+		os.Exit(1)
+	}
+	connectors.SetEncryption(fields)
+
+	// the change events are posted to the webhooks registered in the webhooks file
+	if cfg.Webhooks.File != "" {
+		if err = connectors.LoadWebhooks(cfg.Webhooks.File); err != nil {
+			logger.Error(fmt.Sprintf("Loading webhooks %v", err))
+			os.Exit(1)
+		}
+	}
+
+	conn := connectors.NewClientConnections(cfg, logger)
+	if conn == nil {
+		logger.Error("Unable to initialise client connections")
+		os.Exit(1)
+	}
+
+	// the alerts (5xx responses, lost connections and slow queries) are logged and posted to the alert urls
+	alerts.Set(alerts.FromConfig(conn, logger, cfg.Alerts))
+
+	// hard delete the documents soft deleted more than purge.retentiondays ago, deliver the change events
+	// and watch the backends health, each job returns once its current run is done
+	jobs, stopJobs := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	start := func(job func()) {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			job()
+		}()
+	}
+	start(func() { connectors.PurgeJob(jobs, conn) })
+	start(func() { connectors.WebhookJob(jobs, conn) })
+	start(func() { alerts.Watch(jobs, time.Duration(cfg.Alerts.HealthInterval)*time.Second, conn.Health) })
+
+	// the log level, timeouts, retries, cache ttl and alert thresholds are applied when the config file changes
+	// or on SIGHUP, the other settings need a restart
+	reloader := config.NewReloader(file, cfg, logger, conn.SetConfig, func(c *config.Config) {
+		logger.SetLevel(c.LogLevel)
+		if a := alerts.Get(); a != nil {
+			a.SetThresholds(alerts.ThresholdsOf(c.Alerts))
+		}
+	})
+	start(func() { reloader.Run(jobs, time.Duration(cfg.Watch)*time.Second) })
+
+	srv := startHttpServer(conn, verifier)
+	waitForShutdown(srv, conn, stopJobs, &running)
+}
@@ -0,0 +1,286 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+const (
+	// the triggers
+	ERRORS         string = "errors"
+	CONNECTIONLOST string = "connection_lost"
+	SLOWQUERY      string = "slow_query"
+	// the severities
+	CRITICAL string = "critical"
+	ERROR    string = "error"
+	WARNING  string = "warning"
+	DOWN     string = "DOWN"
+	UP       string = "UP"
+	// the alerts are sent within SENDTIMEOUT
+	SENDTIMEOUT = 10 * time.Second
+)
+
+// Thresholds - ErrorCount 5xx responses within ErrorWindow or a query slower than SlowQuery fire an alert,
+// an alert with the same key is sent at most once per Dedup and at most RateLimit alerts are sent per minute
+type Thresholds struct {
+	ErrorCount  int
+	ErrorWindow time.Duration
+	SlowQuery   time.Duration
+	Dedup       time.Duration
+	RateLimit   int
+}
+
+// DefaultThresholds - 10 5xx in a minute, 2s queries, one alert per key every 5 minutes and 10 alerts per minute
+var DefaultThresholds = Thresholds{ErrorCount: 10, ErrorWindow: time.Minute, SlowQuery: 2 * time.Second, Dedup: 5 * time.Minute, RateLimit: 10}
+
+// Alerter - evaluates the triggers and sends the alerts to all the sinks
+type Alerter struct {
+	sinks []Sink
+	l     *logging.Logger
+	t     Thresholds
+	now   func() time.Time
+	mu    sync.Mutex
+	// the time each key was last sent and how many times it fired since
+	last       map[string]time.Time
+	suppressed map[string]int
+	// the send times of the last minute (rate limit) and the 5xx times within the window
+	sent   []time.Time
+	errors []time.Time
+	health map[string]string
+	wg     sync.WaitGroup
+}
+
+// New - an alerter sending to the sinks, a zero threshold falls back to its default
+func New(l *logging.Logger, t Thresholds, sinks ...Sink) *Alerter {
+	return &Alerter{
+		sinks:      sinks,
+		l:          l,
+		t:          withDefaults(t),
+		now:        time.Now,
+		last:       map[string]time.Time{},
+		suppressed: map[string]int{},
+		health:     map[string]string{},
+	}
+}
+
+// withDefaults - private, replaces the zero thresholds with their default
+func withDefaults(t Thresholds) Thresholds {
+	if t.ErrorCount <= 0 {
+		t.ErrorCount = DefaultThresholds.ErrorCount
+	}
+	if t.ErrorWindow <= 0 {
+		t.ErrorWindow = DefaultThresholds.ErrorWindow
+	}
+	if t.SlowQuery <= 0 {
+		t.SlowQuery = DefaultThresholds.SlowQuery
+	}
+	if t.Dedup <= 0 {
+		t.Dedup = DefaultThresholds.Dedup
+	}
+	if t.RateLimit <= 0 {
+		t.RateLimit = DefaultThresholds.RateLimit
+	}
+	return t
+}
+
+// SetThresholds - changes the thresholds (i.e. on a config reload), a zero threshold falls back to its default
+func (a *Alerter) SetThresholds(t Thresholds) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.t = withDefaults(t)
+}
+
+// Thresholds - the current thresholds
+func (a *Alerter) Thresholds() Thresholds {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.t
+}
+
+// ThresholdsOf - the thresholds of the alerts config (the windows are in seconds and the slow query in milliseconds)
+func ThresholdsOf(c config.Alerts) Thresholds {
+	return Thresholds{
+		ErrorCount:  c.ErrorCount,
+		ErrorWindow: time.Duration(c.ErrorWindow) * time.Second,
+		SlowQuery:   time.Duration(c.SlowQuery) * time.Millisecond,
+		Dedup:       time.Duration(c.Dedup) * time.Second,
+		RateLimit:   c.RateLimit,
+	}
+}
+
+// FromConfig - the alerter of the alerts config, the alerts are always logged and also posted to
+// the slack and webhook urls when they're set
+func FromConfig(client Doer, l *logging.Logger, c config.Alerts) *Alerter {
+	sinks := []Sink{LogSink{Logger: l}}
+	if c.SlackURL != "" {
+		sinks = append(sinks, SlackSink{URL: c.SlackURL, Client: client})
+	}
+	if c.WebhookURL != "" {
+		sinks = append(sinks, WebhookSink{URL: c.WebhookURL, Client: client})
+	}
+	return New(l, ThresholdsOf(c), sinks...)
+}
+
+// allow - private, deduplicates and rate limits the alerts, returns the number of times the key fired
+// since it was last sent (0 if the alert is not sent)
+func (a *Alerter) allow(key string, now time.Time) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.last[key]; ok && now.Sub(last) < a.t.Dedup {
+		a.suppressed[key]++
+		return 0
+	}
+	recent := a.sent[:0]
+	for _, ts := range a.sent {
+		if now.Sub(ts) < time.Minute {
+			recent = append(recent, ts)
+		}
+	}
+	a.sent = recent
+	if len(a.sent) >= a.t.RateLimit {
+		a.suppressed[key]++
+		a.l.Warn(fmt.Sprintf("alert %s rate limited", key))
+		return 0
+	}
+	a.sent = append(a.sent, now)
+	a.last[key] = now
+	count := a.suppressed[key] + 1
+	delete(a.suppressed, key)
+	return count
+}
+
+// Fire - sends the alert to all the sinks in the background unless it's a duplicate or rate limited,
+// returns true if the alert is sent
+func (a *Alerter) Fire(trigger string, key string, severity string, message string) bool {
+	now := a.now()
+	count := a.allow(key, now)
+	if count == 0 {
+		return false
+	}
+	alert := schema.Alert{Trigger: trigger, Key: key, Severity: severity, Message: message, Count: count, Time: now.UTC()}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), SENDTIMEOUT)
+		defer cancel()
+		for _, sink := range a.sinks {
+			if err := sink.Send(ctx, alert); err != nil {
+				a.l.Error(fmt.Sprintf("alert %s not sent to %s %v", key, sink.Name(), err))
+			}
+		}
+	}()
+	return true
+}
+
+// Wait - waits for the alerts being sent
+func (a *Alerter) Wait() {
+	a.wg.Wait()
+}
+
+// ObserveResponse - counts the 5xx responses, ErrorCount of them within ErrorWindow fire an alert
+func (a *Alerter) ObserveResponse(crudl string, code int) {
+	if code < 500 {
+		return
+	}
+	now := a.now()
+	a.mu.Lock()
+	recent := a.errors[:0]
+	for _, ts := range a.errors {
+		if now.Sub(ts) < a.t.ErrorWindow {
+			recent = append(recent, ts)
+		}
+	}
+	a.errors = append(recent, now)
+	count, t := len(a.errors), a.t
+	if count >= t.ErrorCount {
+		a.errors = nil
+	}
+	a.mu.Unlock()
+	if count >= t.ErrorCount {
+		a.Fire(ERRORS, ERRORS, ERROR, fmt.Sprintf("%d 5xx responses in the last %v, the last one %d on %s", count, t.ErrorWindow, code, crudl))
+	}
+}
+
+// ObserveQuery - a database operation slower than SlowQuery fires an alert (one per operation and resource)
+func (a *Alerter) ObserveQuery(operation string, resource string, d time.Duration) {
+	slow := a.Thresholds().SlowQuery
+	if d < slow {
+		return
+	}
+	key := SLOWQUERY + ":" + operation + ":" + resource
+	a.Fire(SLOWQUERY, key, WARNING, fmt.Sprintf("%s on %s took %v (threshold %v)", operation, resource, d.Round(time.Millisecond), slow))
+}
+
+// ObserveHealth - a dependency going from UP to DOWN fires an alert
+func (a *Alerter) ObserveHealth(deps []schema.Dependency) {
+	for _, dep := range deps {
+		a.mu.Lock()
+		prev := a.health[dep.Name]
+		a.health[dep.Name] = dep.Status
+		a.mu.Unlock()
+		if prev == UP && dep.Status == DOWN {
+			a.Fire(CONNECTIONLOST, CONNECTIONLOST+":"+dep.Name, CRITICAL, fmt.Sprintf("%s connection lost %s", dep.Name, dep.Message))
+		}
+	}
+}
+
+var (
+	alerter *Alerter
+	alck    sync.RWMutex
+)
+
+// Set - the alerter used by the handlers and connectors, nil disables the alerts
+func Set(a *Alerter) {
+	alck.Lock()
+	defer alck.Unlock()
+	alerter = a
+}
+
+// Get - the current alerter (nil if the alerts are disabled)
+func Get() *Alerter {
+	alck.RLock()
+	defer alck.RUnlock()
+	return alerter
+}
+
+// Response - ObserveResponse of the current alerter
+func Response(crudl string, code int) {
+	if a := Get(); a != nil {
+		a.ObserveResponse(crudl, code)
+	}
+}
+
+// Query - ObserveQuery of the current alerter
+func Query(operation string, resource string, d time.Duration) {
+	if a := Get(); a != nil {
+		a.ObserveQuery(operation, resource, d)
+	}
+}
+
+// Health - ObserveHealth of the current alerter
+func Health(deps []schema.Dependency) {
+	if a := Get(); a != nil {
+		a.ObserveHealth(deps)
+	}
+}
+
+// Watch - checks the health every interval until the ctx is done so that a lost connection is noticed
+// even without readiness probes
+func Watch(ctx context.Context, interval time.Duration, check func(context.Context) []schema.Dependency) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Health(check(ctx))
+		}
+	}
+}
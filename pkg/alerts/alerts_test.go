@@ -0,0 +1,197 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+func assertEqual(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+// recorder - an httptest server keeping the bodies posted to it
+type recorder struct {
+	srv    *httptest.Server
+	mu     sync.Mutex
+	bodies []string
+}
+
+func newRecorder(status int) *recorder {
+	rec := &recorder{}
+	rec.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.bodies = append(rec.bodies, string(body))
+		rec.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return rec
+}
+
+func (rec *recorder) posted() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string{}, rec.bodies...)
+}
+
+func TestAlerts(t *testing.T) {
+
+	logger := logging.New(os.Stdout, "info")
+
+	t.Run("SlackSink : should post the text", func(t *testing.T) {
+		rec := newRecorder(http.StatusOK)
+		defer rec.srv.Close()
+		a := New(logger, Thresholds{}, SlackSink{URL: rec.srv.URL, Client: rec.srv.Client()})
+		assertEqual(t, a.Fire(CONNECTIONLOST, "mongodb", CRITICAL, "mongodb connection lost"), true)
+		a.Wait()
+		bodies := rec.posted()
+		assertEqual(t, len(bodies), 1)
+		assertEqual(t, bodies[0], `{"text":"[critical] mongodb connection lost"}`)
+	})
+
+	t.Run("WebhookSink : should post the alert", func(t *testing.T) {
+		rec := newRecorder(http.StatusAccepted)
+		defer rec.srv.Close()
+		a := New(logger, Thresholds{}, WebhookSink{URL: rec.srv.URL, Client: rec.srv.Client()}, LogSink{Logger: logger})
+		a.ObserveQuery("DBList", "customer", 3*time.Second)
+		a.Wait()
+		bodies := rec.posted()
+		if len(bodies) != 1 {
+			t.Fatalf(fmt.Sprintf("Test WebhookSink posted - got (%d) wanted (%d) alerts", len(bodies), 1))
+		}
+		var alert schema.Alert
+		json.Unmarshal([]byte(bodies[0]), &alert)
+		assertEqual(t, alert.Trigger, SLOWQUERY)
+		assertEqual(t, alert.Key, "slow_query:DBList:customer")
+		assertEqual(t, alert.Severity, WARNING)
+		assertEqual(t, alert.Count, 1)
+	})
+
+	t.Run("post : should fail on a non 2xx status", func(t *testing.T) {
+		rec := newRecorder(http.StatusInternalServerError)
+		defer rec.srv.Close()
+		err := WebhookSink{URL: rec.srv.URL, Client: rec.srv.Client()}.Send(context.Background(), schema.Alert{})
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test post returned with no error - got (%s) wanted (%s)", "nil", "error"))
+		}
+	})
+
+	t.Run("Fire : should deduplicate the alerts", func(t *testing.T) {
+		rec := newRecorder(http.StatusOK)
+		defer rec.srv.Close()
+		now := time.Now()
+		a := New(logger, Thresholds{Dedup: time.Minute}, WebhookSink{URL: rec.srv.URL, Client: rec.srv.Client()})
+		a.now = func() time.Time { return now }
+		assertEqual(t, a.Fire(ERRORS, "key", ERROR, "first"), true)
+		assertEqual(t, a.Fire(ERRORS, "key", ERROR, "second"), false)
+		assertEqual(t, a.Fire(ERRORS, "other", ERROR, "other"), true)
+		now = now.Add(time.Minute)
+		assertEqual(t, a.Fire(ERRORS, "key", ERROR, "third"), true)
+		a.Wait()
+		var alert schema.Alert
+		bodies := rec.posted()
+		assertEqual(t, len(bodies), 3)
+		for _, body := range bodies {
+			json.Unmarshal([]byte(body), &alert)
+			if alert.Message == "third" {
+				assertEqual(t, alert.Count, 2)
+			}
+		}
+	})
+
+	t.Run("Fire : should rate limit the alerts", func(t *testing.T) {
+		now := time.Now()
+		a := New(logger, Thresholds{RateLimit: 2}, LogSink{Logger: logger})
+		a.now = func() time.Time { return now }
+		assertEqual(t, a.Fire(ERRORS, "1", ERROR, "1"), true)
+		assertEqual(t, a.Fire(ERRORS, "2", ERROR, "2"), true)
+		assertEqual(t, a.Fire(ERRORS, "3", ERROR, "3"), false)
+		now = now.Add(time.Minute)
+		assertEqual(t, a.Fire(ERRORS, "3", ERROR, "3"), true)
+		a.Wait()
+	})
+
+	t.Run("ObserveResponse : should fire on repeated 5xx", func(t *testing.T) {
+		now := time.Now()
+		a := New(logger, Thresholds{ErrorCount: 3, ErrorWindow: time.Minute}, LogSink{Logger: logger})
+		a.now = func() time.Time { return now }
+		a.ObserveResponse("DBGet", http.StatusOK)
+		a.ObserveResponse("DBGet", http.StatusNotFound)
+		a.ObserveResponse("DBGet", http.StatusServiceUnavailable)
+		a.ObserveResponse("DBGet", http.StatusServiceUnavailable)
+		assertEqual(t, len(a.sent), 0)
+		// the first 5xx is out of the window
+		now = now.Add(time.Minute)
+		a.ObserveResponse("DBGet", http.StatusGatewayTimeout)
+		a.ObserveResponse("DBGet", http.StatusGatewayTimeout)
+		assertEqual(t, len(a.sent), 0)
+		a.ObserveResponse("DBList", http.StatusInternalServerError)
+		assertEqual(t, len(a.sent), 1)
+		a.Wait()
+	})
+
+	t.Run("ObserveHealth : should fire when a dependency goes down", func(t *testing.T) {
+		a := New(logger, Thresholds{}, LogSink{Logger: logger})
+		down := []schema.Dependency{{Name: "mongodb", Status: DOWN, Message: "server selection error"}}
+		// down at startup is not a lost connection
+		a.ObserveHealth(down)
+		a.ObserveHealth([]schema.Dependency{{Name: "mongodb", Status: UP}})
+		assertEqual(t, len(a.sent), 0)
+		a.ObserveHealth(down)
+		a.ObserveHealth(down)
+		assertEqual(t, len(a.sent), 1)
+		_, ok := a.last["connection_lost:mongodb"]
+		assertEqual(t, ok, true)
+		a.Wait()
+	})
+
+	t.Run("ObserveQuery : fast queries should not fire", func(t *testing.T) {
+		a := New(logger, Thresholds{SlowQuery: time.Second}, LogSink{Logger: logger})
+		a.ObserveQuery("DBGet", "customer", 10*time.Millisecond)
+		assertEqual(t, len(a.sent), 0)
+	})
+
+	t.Run("FromConfig : should add the configured sinks", func(t *testing.T) {
+		c := config.Default().Alerts
+		c.SlackURL = "http://slack"
+		c.WebhookURL = "http://webhook"
+		c.SlowQuery = 500
+		a := FromConfig(http.DefaultClient, logger, c)
+		assertEqual(t, len(a.sinks), 3)
+		assertEqual(t, a.sinks[1].Name(), "slack")
+		assertEqual(t, a.t.SlowQuery, 500*time.Millisecond)
+		assertEqual(t, a.t.ErrorCount, DefaultThresholds.ErrorCount)
+		assertEqual(t, a.t.Dedup, DefaultThresholds.Dedup)
+	})
+
+	t.Run("SetThresholds : should change the thresholds", func(t *testing.T) {
+		a := New(logger, Thresholds{}, LogSink{Logger: logger})
+		a.SetThresholds(Thresholds{SlowQuery: 10 * time.Millisecond})
+		assertEqual(t, a.Thresholds().SlowQuery, 10*time.Millisecond)
+		assertEqual(t, a.Thresholds().RateLimit, DefaultThresholds.RateLimit)
+		a.ObserveQuery("DBGet", "customer", 20*time.Millisecond)
+		assertEqual(t, len(a.sent), 1)
+		a.Wait()
+	})
+
+	t.Run("Response : no alerter should be a no-op", func(t *testing.T) {
+		Set(nil)
+		Response("DBGet", http.StatusInternalServerError)
+		Query("DBGet", "customer", time.Hour)
+		Health(nil)
+	})
+}
@@ -0,0 +1,93 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+// Doer - the http client the alerts are posted with (connectors.Clients implements it)
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Sink - a destination of the alerts
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, alert schema.Alert) error
+}
+
+// SlackSink - posts the alerts as the text of a Slack (or compatible i.e. Mattermost, Teams) incoming webhook
+type SlackSink struct {
+	URL    string
+	Client Doer
+}
+
+// WebhookSink - posts the alerts as json to a generic webhook
+type WebhookSink struct {
+	URL    string
+	Client Doer
+}
+
+// LogSink - only logs the alerts
+type LogSink struct {
+	Logger *logging.Logger
+}
+
+func (s SlackSink) Name() string {
+	return "slack"
+}
+
+// Send - the text is "[severity] message" followed by the number of times the alert fired
+func (s SlackSink) Send(ctx context.Context, alert schema.Alert) error {
+	text := fmt.Sprintf("[%s] %s", alert.Severity, alert.Message)
+	if alert.Count > 1 {
+		text = fmt.Sprintf("%s (fired %d times)", text, alert.Count)
+	}
+	body, _ := json.Marshal(map[string]string{"text": text})
+	return post(ctx, s.Client, s.URL, body)
+}
+
+func (s WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s WebhookSink) Send(ctx context.Context, alert schema.Alert) error {
+	body, _ := json.Marshal(alert)
+	return post(ctx, s.Client, s.URL, body)
+}
+
+func (s LogSink) Name() string {
+	return "log"
+}
+
+func (s LogSink) Send(ctx context.Context, alert schema.Alert) error {
+	s.Logger.Warn("alert "+alert.Message, "trigger", alert.Trigger, "key", alert.Key, "severity", alert.Severity, "count", alert.Count)
+	return nil
+}
+
+// post - private, posts the json body, any status other than 2xx is an error
+func post(ctx context.Context, client Doer, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	BEARER string = "Bearer "
+	// clock skew allowed on the exp and nbf claims
+	LEEWAY time.Duration = 30 * time.Second
+)
+
+// ErrUnauthenticated - the token is missing or not valid (wrapped with the reason)
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Claims - the verified claims of a token
+type Claims map[string]interface{}
+
+// Subject - the sub claim (empty if not set)
+func (c Claims) Subject() string {
+	sub, _ := c["sub"].(string)
+	return sub
+}
+
+type contextKey struct{}
+
+// WithClaims - a ctx carrying the verified claims
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// FromContext - the verified claims of the request, false if the request was not authenticated
+func FromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(contextKey{}).(Claims)
+	return claims, ok
+}
+
+// Verifier - checks the signature and the registered claims (exp, nbf, iss and aud) of the bearer tokens
+// HS256 tokens are verified with the shared secret and RS256 tokens with the key of their kid in the JWKS
+type Verifier struct {
+	secret   []byte
+	keys     map[string]*rsa.PublicKey
+	issuer   string
+	audience string
+}
+
+// NewVerifier - a verifier for the shared secret and/or the keys of the jwks file (either can be empty but not both)
+// issuer and audience are checked when set
+func NewVerifier(secret string, jwksFile string, issuer string, audience string) (*Verifier, error) {
+	v := &Verifier{secret: []byte(secret), issuer: issuer, audience: audience}
+	if jwksFile != "" {
+		keys, err := LoadJWKS(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+	if len(v.secret) == 0 && len(v.keys) == 0 {
+		return nil, errors.New("no JWT_SECRET or JWT_JWKS_FILE keys to verify the tokens")
+	}
+	return v, nil
+}
+
+// VerifierFromConfig - the verifier configured with the auth secret, jwks file, issuer and audience
+// returns nil (no authentication) if the auth is disabled
+func VerifierFromConfig(c config.Auth) (*Verifier, error) {
+	if c.Disabled {
+		return nil, nil
+	}
+	return NewVerifier(c.Secret, c.JWKSFile, c.Issuer, c.Audience)
+}
+
+// jwk - the rsa fields of a json web key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// LoadJWKS - reads the rsa signing keys of a json web key set file, keyed by kid
+func LoadJWKS(file string) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("jwks %s %v", file, err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := rsaKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s key %s %v", file, k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("jwks %s has no rsa signing keys", file)
+	}
+	return keys, nil
+}
+
+// rsaKey - private, the public key from the base64url modulus and exponent
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("modulus or exponent not valid")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+}
+
+// key - private, the key of the token signing method, the method is checked so that
+// a HS256 token can't be signed with a public rsa key
+func (v *Verifier) key(t *jwt.Token) (interface{}, error) {
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if len(v.secret) == 0 {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := t.Header["kid"].(string)
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		// a single key can be used by tokens without a kid
+		if kid == "" && len(v.keys) == 1 {
+			for _, key := range v.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("no key for kid %q", kid)
+	}
+	return nil, fmt.Errorf("signing method %s is not accepted", t.Method.Alg())
+}
+
+// Verify - the claims of a valid token, the token must have an exp claim
+func (v *Verifier) Verify(token string) (Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(LEEWAY),
+	}
+	if v.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		opts = append(opts, jwt.WithAudience(v.audience))
+	}
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, v.key, opts...); err != nil {
+		return nil, fmt.Errorf("%w : %v", ErrUnauthenticated, err)
+	}
+	return Claims(claims), nil
+}
+
+// BearerToken - the token of an "Authorization: Bearer token" header
+func BearerToken(header string) (string, error) {
+	if len(header) < len(BEARER) || !strings.EqualFold(header[:len(BEARER)], BEARER) {
+		return "", fmt.Errorf("%w : no bearer token", ErrUnauthenticated)
+	}
+	token := strings.TrimSpace(header[len(BEARER):])
+	if token == "" {
+		return "", fmt.Errorf("%w : no bearer token", ErrUnauthenticated)
+	}
+	return token, nil
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func assertEqual(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+// writeJWKS - the jwks file of the public key
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	set := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	b, _ := json.Marshal(set)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatalf(fmt.Sprintf("Test jwks %s returned with error - got (%v) wanted (%s)", file, err, "nil"))
+	}
+	return file
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf(fmt.Sprintf("Test sign %s returned with error - got (%v) wanted (%s)", method.Alg(), err, "nil"))
+	}
+	return s
+}
+
+func TestAuth(t *testing.T) {
+
+	secret := []byte("a-shared-secret")
+	private, _ := rsa.GenerateKey(rand.Reader, 2048)
+	jwks := writeJWKS(t, "key-1", &private.PublicKey)
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "user-1", "iss": "test", "aud": "dbservice", "exp": time.Now().Add(time.Minute).Unix()}
+	}
+
+	t.Run("Verify : HS256 and RS256 tokens should pass", func(t *testing.T) {
+		v, err := NewVerifier(string(secret), jwks, "test", "dbservice")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test NewVerifier returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		for _, token := range []string{
+			sign(t, jwt.SigningMethodHS256, "", secret, valid()),
+			sign(t, jwt.SigningMethodRS256, "key-1", private, valid()),
+			sign(t, jwt.SigningMethodRS256, "", private, valid()),
+		} {
+			claims, err := v.Verify(token)
+			if err != nil {
+				t.Errorf(fmt.Sprintf("Test Verify returned with error - got (%v) wanted (%s)", err, "nil"))
+				continue
+			}
+			assertEqual(t, claims.Subject(), "user-1")
+		}
+	})
+
+	t.Run("Verify : invalid tokens should fail", func(t *testing.T) {
+		v, _ := NewVerifier(string(secret), jwks, "test", "dbservice")
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		expired := valid()
+		expired["exp"] = time.Now().Add(-time.Hour).Unix()
+		noexp := valid()
+		delete(noexp, "exp")
+		issuer := valid()
+		issuer["iss"] = "nada"
+		audience := valid()
+		audience["aud"] = "nada"
+		none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		for name, token := range map[string]string{
+			"wrong secret": sign(t, jwt.SigningMethodHS256, "", []byte("nada"), valid()),
+			"wrong key":    sign(t, jwt.SigningMethodRS256, "key-1", other, valid()),
+			"unknown kid":  sign(t, jwt.SigningMethodRS256, "key-2", private, valid()),
+			"expired":      sign(t, jwt.SigningMethodHS256, "", secret, expired),
+			"no exp":       sign(t, jwt.SigningMethodHS256, "", secret, noexp),
+			"issuer":       sign(t, jwt.SigningMethodHS256, "", secret, issuer),
+			"audience":     sign(t, jwt.SigningMethodHS256, "", secret, audience),
+			"alg none":     none,
+			"garbage":      "nada",
+		} {
+			if _, err := v.Verify(token); !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf(fmt.Sprintf("Test Verify %s returned - got (%v) wanted (%v)", name, err, ErrUnauthenticated))
+			}
+		}
+	})
+
+	t.Run("Verify : HS256 should fail without a shared secret", func(t *testing.T) {
+		v, _ := NewVerifier("", jwks, "", "")
+		// i.e. a token signed with the public key as the hmac secret
+		token := sign(t, jwt.SigningMethodHS256, "key-1", private.PublicKey.N.Bytes(), valid())
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf(fmt.Sprintf("Test Verify %s returned with no error - got (%s) wanted (%s)", "HS256", "nil", "error"))
+		}
+	})
+
+	t.Run("NewVerifier : should fail without keys", func(t *testing.T) {
+		for _, file := range []string{"", "../../tests/nada.json", "../../tests/config-parse-error.json", "../../tests/resources.json"} {
+			if _, err := NewVerifier("", file, "", ""); err == nil {
+				t.Errorf(fmt.Sprintf("Test NewVerifier %s returned with no error - got (%s) wanted (%s)", file, "nil", "error"))
+			}
+		}
+	})
+
+	t.Run("VerifierFromConfig : should be disabled by auth.disabled", func(t *testing.T) {
+		v, err := VerifierFromConfig(config.Auth{Disabled: true, Secret: "secret"})
+		if v != nil || err != nil {
+			t.Errorf(fmt.Sprintf("Test VerifierFromConfig returned - got (%v, %v) wanted (%s)", v, err, "nil"))
+		}
+		if _, err = VerifierFromConfig(config.Auth{}); err == nil {
+			t.Errorf(fmt.Sprintf("Test VerifierFromConfig returned with no error - got (%s) wanted (%s)", "nil", "error"))
+		}
+	})
+
+	t.Run("BearerToken : should parse the header", func(t *testing.T) {
+		token, err := BearerToken("bearer abc.def.ghi")
+		assertEqual(t, token, "abc.def.ghi")
+		assertEqual(t, err, nil)
+		for _, header := range []string{"", "Bearer ", "Basic dXNlcjpwYXNz", "abc.def.ghi"} {
+			if _, err = BearerToken(header); !errors.Is(err, ErrUnauthenticated) {
+				t.Errorf(fmt.Sprintf("Test BearerToken %q returned - got (%v) wanted (%v)", header, err, ErrUnauthenticated))
+			}
+		}
+	})
+
+	t.Run("FromContext : should return the claims", func(t *testing.T) {
+		_, ok := FromContext(context.Background())
+		assertEqual(t, ok, false)
+		claims, ok := FromContext(WithClaims(context.Background(), Claims{"sub": "user-1"}))
+		assertEqual(t, ok, true)
+		assertEqual(t, claims.Subject(), "user-1")
+	})
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+const (
+	ANY       string = "*"
+	ROLECLAIM string = "roles"
+	// claim set on the claims of an api key, its roles are in the roles claim
+	APIKEYCLAIM string = "apikey"
+)
+
+// ErrForbidden - the roles of the caller don't allow the operation (wrapped with the reason)
+var ErrForbidden = errors.New("forbidden")
+
+var (
+	policy *schema.Policy
+	keys   map[string]schema.APIKey
+	plck   sync.RWMutex
+)
+
+// SetPolicy - validates and sets (or with nil clears) the access policy
+// Without a policy every authenticated request is allowed
+func SetPolicy(p *schema.Policy) error {
+	idx := make(map[string]schema.APIKey)
+	if p != nil {
+		if p.RoleClaim == "" {
+			p.RoleClaim = ROLECLAIM
+		}
+		for role, rules := range p.Roles {
+			for _, rule := range rules {
+				if len(rule.Operations) == 0 || len(rule.Resources) == 0 {
+					return fmt.Errorf("role %s has a rule without operations or resources", role)
+				}
+			}
+		}
+		for _, key := range p.APIKeys {
+			sum := strings.ToLower(key.SHA256)
+			if b, err := hex.DecodeString(sum); err != nil || len(b) != sha256.Size || key.Name == "" {
+				return fmt.Errorf("api key %q needs a name and a hex sha256", key.Name)
+			}
+			for _, role := range key.Roles {
+				if _, ok := p.Roles[role]; !ok {
+					return fmt.Errorf("api key %s role %s is not defined", key.Name, role)
+				}
+			}
+			idx[sum] = key
+		}
+	}
+	plck.Lock()
+	defer plck.Unlock()
+	policy, keys = p, idx
+	return nil
+}
+
+// LoadPolicy - sets the access policy defined in a json file (a schema.Policy)
+func LoadPolicy(file string) error {
+	var p schema.Policy
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("policy file %s : %v", file, err)
+	}
+	if err = SetPolicy(&p); err != nil {
+		return fmt.Errorf("policy file %s : %v", file, err)
+	}
+	return nil
+}
+
+// HasAPIKeys - true if the policy defines api keys
+func HasAPIKeys() bool {
+	plck.RLock()
+	defer plck.RUnlock()
+	return len(keys) > 0
+}
+
+// APIKeyClaims - the claims of a known api key (sub is the key name)
+func APIKeyClaims(key string) (Claims, bool) {
+	sum := sha256.Sum256([]byte(key))
+	plck.RLock()
+	defer plck.RUnlock()
+	k, ok := keys[hex.EncodeToString(sum[:])]
+	if !ok {
+		return nil, false
+	}
+	list := make([]interface{}, len(k.Roles))
+	for x, role := range k.Roles {
+		list[x] = role
+	}
+	return Claims{"sub": k.Name, APIKEYCLAIM: k.Name, ROLECLAIM: list}, true
+}
+
+// Roles - the roles of the claims, the role claim can be a list or a space separated string
+func Roles(claims Claims) []string {
+	plck.RLock()
+	defer plck.RUnlock()
+	return roles(claims)
+}
+
+// roles - private, Roles with the policy lock held
+func roles(claims Claims) []string {
+	path := ROLECLAIM
+	if policy != nil {
+		path = policy.RoleClaim
+	}
+	if _, ok := claims[APIKEYCLAIM]; ok {
+		path = ROLECLAIM
+	}
+
+	var val interface{} = map[string]interface{}(claims)
+	for _, key := range strings.Split(path, ".") {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		val = m[key]
+	}
+	var list []string
+	switch v := val.(type) {
+	case string:
+		list = strings.Fields(v)
+	case []interface{}:
+		for _, role := range v {
+			if s, ok := role.(string); ok {
+				list = append(list, s)
+			}
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Authorize - checks that one of the roles of the claims allows the operation on the resource
+// nil claims (an unauthenticated request) are only allowed without a policy
+func Authorize(claims Claims, operation string, resource string) error {
+	plck.RLock()
+	defer plck.RUnlock()
+	if policy == nil {
+		return nil
+	}
+	if claims == nil {
+		return fmt.Errorf("%w : %s on %s needs an authenticated caller", ErrForbidden, operation, resource)
+	}
+	list := roles(claims)
+	for _, role := range list {
+		for _, rule := range policy.Roles[role] {
+			if match(rule.Operations, operation) && match(rule.Resources, resource) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w : %s on %s is not allowed for %s with roles %v", ErrForbidden, operation, resource, claims.Subject(), list)
+}
+
+// match - private, the value is in the list or the list has "*"
+func match(list []string, val string) bool {
+	for _, item := range list {
+		if item == ANY || item == val {
+			return true
+		}
+	}
+	return false
+}
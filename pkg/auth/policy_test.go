@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+func TestPolicy(t *testing.T) {
+
+	defer SetPolicy(nil)
+	reader := Claims{"sub": "user-1", "realm_access": map[string]interface{}{"roles": []interface{}{"reader"}}}
+
+	t.Run("Authorize : no policy should allow all", func(t *testing.T) {
+		SetPolicy(nil)
+		assertEqual(t, Authorize(nil, "DBDelete", "customer"), nil)
+		assertEqual(t, HasAPIKeys(), false)
+	})
+
+	t.Run("LoadPolicy : should pass", func(t *testing.T) {
+		if err := LoadPolicy("../../tests/policy.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Test LoadPolicy returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		assertEqual(t, HasAPIKeys(), true)
+	})
+
+	t.Run("Authorize : should check the roles", func(t *testing.T) {
+		LoadPolicy("../../tests/policy.json")
+		admin := Claims{"sub": "user-2", "realm_access": map[string]interface{}{"roles": []interface{}{"admin"}}}
+		key, _ := APIKeyClaims("test-api-key")
+		for _, tc := range []struct {
+			name      string
+			claims    Claims
+			operation string
+			resource  string
+			allowed   bool
+		}{
+			{"reader get", reader, "DBGet", "watchlist", true},
+			{"reader delete", reader, "DBDelete", "customer", false},
+			{"admin delete", admin, "DBDelete", "watchlist", true},
+			{"api key insert", key, "DBInsert", "publications", true},
+			{"api key other resource", key, "DBInsert", "watchlist", false},
+			{"api key delete", key, "DBBulkDelete", "customer", false},
+			{"no roles", Claims{"sub": "user-3"}, "DBGet", "customer", false},
+			{"unauthenticated", nil, "DBGet", "customer", false},
+		} {
+			err := Authorize(tc.claims, tc.operation, tc.resource)
+			if (err == nil) != tc.allowed || (err != nil && !errors.Is(err, ErrForbidden)) {
+				t.Errorf(fmt.Sprintf("Test Authorize %s returned - got (%v) wanted allowed (%t)", tc.name, err, tc.allowed))
+			}
+		}
+	})
+
+	t.Run("APIKeyClaims : unknown keys should fail", func(t *testing.T) {
+		LoadPolicy("../../tests/policy.json")
+		_, ok := APIKeyClaims("nada")
+		assertEqual(t, ok, false)
+		claims, ok := APIKeyClaims("test-api-key")
+		assertEqual(t, ok, true)
+		assertEqual(t, claims.Subject(), "batch-import")
+	})
+
+	t.Run("Roles : should read lists and strings", func(t *testing.T) {
+		SetPolicy(&schema.Policy{RoleClaim: "scope", Roles: map[string][]schema.Rule{}})
+		assertEqual(t, fmt.Sprint(Roles(Claims{"scope": "writer reader"})), "[reader writer]")
+		assertEqual(t, len(Roles(Claims{"scope": 42})), 0)
+		SetPolicy(&schema.Policy{Roles: map[string][]schema.Rule{}})
+		assertEqual(t, fmt.Sprint(Roles(Claims{"roles": []interface{}{"reader", 1}})), "[reader]")
+	})
+
+	t.Run("LoadPolicy : should fail", func(t *testing.T) {
+		for _, file := range []string{"../../tests/nada.json", "../../tests/config-parse-error.json", "../../tests/policy-error.json"} {
+			if err := LoadPolicy(file); err == nil {
+				t.Errorf(fmt.Sprintf("Test LoadPolicy %s returned with no error - got (%s) wanted (%s)", file, "nil", "error"))
+			}
+		}
+		err := SetPolicy(&schema.Policy{Roles: map[string][]schema.Rule{"reader": {{Operations: []string{"DBGet"}}}}})
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test SetPolicy %s returned with no error - got (%s) wanted (%s)", "no resources", "nil", "error"))
+		}
+	})
+}
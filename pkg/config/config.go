@@ -0,0 +1,374 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// the durations are in seconds unless the name says otherwise
+	DEFAULTSHUTDOWN       int    = 30
+	DEFAULTTIMEOUT        int    = 10
+	DEFAULTRETRIES        int    = 2
+	DEFAULTTTL            int    = 300
+	DEFAULTRETENTION      int    = 30
+	DEFAULTPURGE          int    = 3600
+	DEFAULTWEBHOOKWORKERS int    = 4
+	DEFAULTWEBHOOKRETRIES int    = 3
+	DEFAULTWEBHOOKTIMEOUT int    = 10
+	DEFAULTERRORCOUNT     int    = 10
+	DEFAULTERRORWINDOW    int    = 60
+	DEFAULTSLOWQUERY      int    = 2000
+	DEFAULTDEDUP          int    = 300
+	DEFAULTRATELIMIT      int    = 10
+	DEFAULTHEALTH         int    = 30
+	DEFAULTWATCH          int    = 10
+	AUDITCOLLECTION       string = "audit"
+	DELIVERYCOLLECTION    string = "deliveries"
+)
+
+// Config - the service settings, loaded from the CONFIG_FILE (yaml or json) and then overridden by the envars
+// the file is checked for changes every Watch seconds (0 only reloads it on SIGHUP)
+type Config struct {
+	Version    string     `json:"version" yaml:"version"`
+	LogLevel   string     `json:"loglevel" yaml:"loglevel"`
+	Resources  string     `json:"resources" yaml:"resources"`
+	Watch      int        `json:"watch" yaml:"watch"`
+	Server     Server     `json:"server" yaml:"server"`
+	MongoDB    MongoDB    `json:"mongodb" yaml:"mongodb"`
+	Redis      Redis      `json:"redis" yaml:"redis"`
+	Operation  Operation  `json:"operation" yaml:"operation"`
+	Cache      Cache      `json:"cache" yaml:"cache"`
+	Purge      Purge      `json:"purge" yaml:"purge"`
+	Audit      Audit      `json:"audit" yaml:"audit"`
+	Webhooks   Webhooks   `json:"webhooks" yaml:"webhooks"`
+	Alerts     Alerts     `json:"alerts" yaml:"alerts"`
+	Auth       Auth       `json:"auth" yaml:"auth"`
+	Encryption Encryption `json:"encryption" yaml:"encryption"`
+}
+
+type Server struct {
+	Port            string `json:"port" yaml:"port"`
+	ShutdownTimeout int    `json:"shutdowntimeout" yaml:"shutdowntimeout"`
+}
+
+// MongoDB - URI is used as is, otherwise the connection string is built from the host, port and replicaset,
+// the documents are stored in Database and the user is authenticated against AuthSource (defaults to Database)
+type MongoDB struct {
+	URI        string `json:"uri" yaml:"uri"`
+	Host       string `json:"host" yaml:"host"`
+	Port       string `json:"port" yaml:"port"`
+	ReplicaSet string `json:"replicaset" yaml:"replicaset"`
+	Database   string `json:"database" yaml:"database"`
+	AuthSource string `json:"authsource" yaml:"authsource"`
+	User       string `json:"user" yaml:"user"`
+	Password   string `json:"password" yaml:"password"`
+}
+
+type Redis struct {
+	Host     string `json:"host" yaml:"host"`
+	Port     string `json:"port" yaml:"port"`
+	Password string `json:"password" yaml:"password"`
+}
+
+// Operation - the deadline of a database operation and the retries of a read while the database is unreachable
+type Operation struct {
+	Timeout int `json:"timeout" yaml:"timeout"`
+	Retries int `json:"retries" yaml:"retries"`
+}
+
+// Cache - the DBGet cache expiry, 0 disables the cache
+type Cache struct {
+	TTL int `json:"ttl" yaml:"ttl"`
+}
+
+// Purge - how long (days) soft deleted documents are kept (0 disables the purge) and the time between purges
+type Purge struct {
+	RetentionDays int `json:"retentiondays" yaml:"retentiondays"`
+	Interval      int `json:"interval" yaml:"interval"`
+}
+
+type Audit struct {
+	Collection string `json:"collection" yaml:"collection"`
+}
+
+// Webhooks - the webhooks file, the delivery log collection and the delivery workers, retries and timeout
+type Webhooks struct {
+	File       string `json:"file" yaml:"file"`
+	Collection string `json:"collection" yaml:"collection"`
+	Workers    int    `json:"workers" yaml:"workers"`
+	Retries    int    `json:"retries" yaml:"retries"`
+	Timeout    int    `json:"timeout" yaml:"timeout"`
+}
+
+// Alerts - the alert thresholds (SlowQuery is in milliseconds) and the urls the alerts are posted to
+type Alerts struct {
+	ErrorCount     int    `json:"errorcount" yaml:"errorcount"`
+	ErrorWindow    int    `json:"errorwindow" yaml:"errorwindow"`
+	SlowQuery      int    `json:"slowquery" yaml:"slowquery"`
+	Dedup          int    `json:"dedup" yaml:"dedup"`
+	RateLimit      int    `json:"ratelimit" yaml:"ratelimit"`
+	HealthInterval int    `json:"healthinterval" yaml:"healthinterval"`
+	SlackURL       string `json:"slackurl" yaml:"slackurl"`
+	WebhookURL     string `json:"webhookurl" yaml:"webhookurl"`
+}
+
+// Auth - the bearer token keys and the access policy file, Disabled serves the crudl endpoints without authentication
+type Auth struct {
+	Disabled   bool   `json:"disabled" yaml:"disabled"`
+	Secret     string `json:"secret" yaml:"secret"`
+	JWKSFile   string `json:"jwksfile" yaml:"jwksfile"`
+	Issuer     string `json:"issuer" yaml:"issuer"`
+	Audience   string `json:"audience" yaml:"audience"`
+	PolicyFile string `json:"policyfile" yaml:"policyfile"`
+}
+
+// Encryption - the key file takes precedence over the (base64) key, Fields overrides the encrypted fields
+type Encryption struct {
+	Key     string   `json:"key" yaml:"key"`
+	KeyFile string   `json:"keyfile" yaml:"keyfile"`
+	Fields  []string `json:"fields" yaml:"fields"`
+}
+
+// Default - the settings used when neither the file nor the envars set them
+func Default() *Config {
+	return &Config{
+		LogLevel:  "info",
+		Watch:     DEFAULTWATCH,
+		Server:    Server{ShutdownTimeout: DEFAULTSHUTDOWN},
+		Operation: Operation{Timeout: DEFAULTTIMEOUT, Retries: DEFAULTRETRIES},
+		Cache:     Cache{TTL: DEFAULTTTL},
+		Purge:     Purge{RetentionDays: DEFAULTRETENTION, Interval: DEFAULTPURGE},
+		Audit:     Audit{Collection: AUDITCOLLECTION},
+		Webhooks: Webhooks{
+			Collection: DELIVERYCOLLECTION,
+			Workers:    DEFAULTWEBHOOKWORKERS,
+			Retries:    DEFAULTWEBHOOKRETRIES,
+			Timeout:    DEFAULTWEBHOOKTIMEOUT,
+		},
+		Alerts: Alerts{
+			ErrorCount:     DEFAULTERRORCOUNT,
+			ErrorWindow:    DEFAULTERRORWINDOW,
+			SlowQuery:      DEFAULTSLOWQUERY,
+			Dedup:          DEFAULTDEDUP,
+			RateLimit:      DEFAULTRATELIMIT,
+			HealthInterval: DEFAULTHEALTH,
+		},
+	}
+}
+
+// setting - private, the name of a setting (its envar or its key) and a pointer to its value
+type setting struct {
+	name string
+	val  interface{}
+}
+
+// envars - private, the envars overriding the settings, a legacy name is listed before the current one so that
+// the current one wins when both are set
+func (c *Config) envars() []setting {
+	return []setting{
+		{"VERSION", &c.Version},
+		{"LOG_LEVEL", &c.LogLevel},
+		{"RESOURCES_FILE", &c.Resources},
+		{"CONFIG_WATCH_INTERVAL", &c.Watch},
+		{"SERVER_PORT", &c.Server.Port},
+		{"SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout},
+		{"MONGODB_URI", &c.MongoDB.URI},
+		{"MONGODB_HOST", &c.MongoDB.Host},
+		{"MONGODB_PORT", &c.MongoDB.Port},
+		{"MONGODB_REPLICASET", &c.MongoDB.ReplicaSet},
+		{"MONGODB_DATABASENAME", &c.MongoDB.Database},
+		{"MONGODB_DATABASE", &c.MongoDB.AuthSource},
+		{"MONGODB_AUTHSOURCE", &c.MongoDB.AuthSource},
+		{"MONGODB_USER", &c.MongoDB.User},
+		{"MONGODB_PASSWORD", &c.MongoDB.Password},
+		{"REDIS_HOST", &c.Redis.Host},
+		{"REDIS_PORT", &c.Redis.Port},
+		{"REDIS_PASSWORD", &c.Redis.Password},
+		{"OPERATION_TIMEOUT", &c.Operation.Timeout},
+		{"OPERATION_RETRIES", &c.Operation.Retries},
+		{"CACHE_TTL", &c.Cache.TTL},
+		{"PURGE_RETENTION_DAYS", &c.Purge.RetentionDays},
+		{"PURGE_INTERVAL", &c.Purge.Interval},
+		{"AUDIT_COLLECTION", &c.Audit.Collection},
+		{"WEBHOOKS_FILE", &c.Webhooks.File},
+		{"WEBHOOK_COLLECTION", &c.Webhooks.Collection},
+		{"WEBHOOK_WORKERS", &c.Webhooks.Workers},
+		{"WEBHOOK_RETRIES", &c.Webhooks.Retries},
+		{"WEBHOOK_TIMEOUT", &c.Webhooks.Timeout},
+		{"ALERT_5XX_COUNT", &c.Alerts.ErrorCount},
+		{"ALERT_5XX_WINDOW", &c.Alerts.ErrorWindow},
+		{"ALERT_SLOW_QUERY", &c.Alerts.SlowQuery},
+		{"ALERT_DEDUP", &c.Alerts.Dedup},
+		{"ALERT_RATE_LIMIT", &c.Alerts.RateLimit},
+		{"ALERT_HEALTH_INTERVAL", &c.Alerts.HealthInterval},
+		{"SLACK_URL", &c.Alerts.SlackURL},
+		{"ALERT_SLACK_URL", &c.Alerts.SlackURL},
+		{"ALERT_WEBHOOK_URL", &c.Alerts.WebhookURL},
+		{"AUTH_DISABLED", &c.Auth.Disabled},
+		{"JWT_SECRET", &c.Auth.Secret},
+		{"JWT_JWKS_FILE", &c.Auth.JWKSFile},
+		{"JWT_ISSUER", &c.Auth.Issuer},
+		{"JWT_AUDIENCE", &c.Auth.Audience},
+		{"POLICY_FILE", &c.Auth.PolicyFile},
+		{"ENCRYPTION_KEY", &c.Encryption.Key},
+		{"ENCRYPTION_KEYFILE", &c.Encryption.KeyFile},
+		{"ENCRYPTION_FIELDS", &c.Encryption.Fields},
+	}
+}
+
+// Load - the defaults overridden by the file (if not empty) and then by the envars, the file is parsed as yaml
+// if its extension is .yaml or .yml and as json otherwise, the config is validated before it's returned
+func Load(file string) (*Config, error) {
+	c := Default()
+	if file != "" {
+		if err := c.read(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyEnv(); err != nil {
+		return nil, err
+	}
+	if c.MongoDB.AuthSource == "" {
+		c.MongoDB.AuthSource = c.MongoDB.Database
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// read - private, overrides the settings set in the file, an unknown key (i.e. a typo) is an error in both formats
+func (c *Config) read(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, c)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err = dec.Decode(c); err == nil && dec.More() {
+			err = errors.New("unexpected data after the json object")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s : %v", file, err)
+	}
+	return nil
+}
+
+// applyEnv - private, overrides the settings of the envars that are set, an envar that can't be parsed is an error
+func (c *Config) applyEnv() error {
+	var errs []error
+	for _, e := range c.envars() {
+		val := os.Getenv(e.name)
+		if val == "" {
+			continue
+		}
+		switch p := e.val.(type) {
+		case *string:
+			*p = val
+		case *int:
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q is not a number", e.name, val))
+				continue
+			}
+			*p = n
+		case *bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q is not a boolean", e.name, val))
+				continue
+			}
+			*p = b
+		case *[]string:
+			*p = strings.Split(val, ",")
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate - checks the mandatory settings and the ranges, all the errors are returned (one per line)
+func (c *Config) Validate() error {
+	var errs []error
+	mandatory := func(val string, name string, envar string) {
+		if val == "" {
+			errs = append(errs, fmt.Errorf("%s (%s) is mandatory", name, envar))
+		}
+	}
+	port := func(val string, name string, envar string) {
+		if n, err := strconv.Atoi(val); val != "" && (err != nil || n <= 0 || n > 65535) {
+			errs = append(errs, fmt.Errorf("%s (%s) %q is not a valid port", name, envar, val))
+		}
+	}
+	atLeast := func(val int, min int, name string, envar string) {
+		if val < min {
+			errs = append(errs, fmt.Errorf("%s (%s) %d must be at least %d", name, envar, val, min))
+		}
+	}
+	link := func(val string, name string, envar string) {
+		if u, err := url.Parse(val); val != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+			errs = append(errs, fmt.Errorf("%s (%s) %q is not a valid url", name, envar, val))
+		}
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "error", "warn", "info", "debug", "trace":
+	default:
+		errs = append(errs, fmt.Errorf("loglevel (LOG_LEVEL) %q is not one of error, warn, info, debug or trace", c.LogLevel))
+	}
+	atLeast(c.Watch, 0, "watch", "CONFIG_WATCH_INTERVAL")
+	mandatory(c.Server.Port, "server.port", "SERVER_PORT")
+	port(c.Server.Port, "server.port", "SERVER_PORT")
+	atLeast(c.Server.ShutdownTimeout, 1, "server.shutdowntimeout", "SHUTDOWN_TIMEOUT")
+	if c.MongoDB.URI == "" {
+		mandatory(c.MongoDB.Host, "mongodb.host", "MONGODB_HOST")
+		if c.MongoDB.ReplicaSet == "" {
+			mandatory(c.MongoDB.Port, "mongodb.port", "MONGODB_PORT")
+			port(c.MongoDB.Port, "mongodb.port", "MONGODB_PORT")
+		}
+	}
+	mandatory(c.MongoDB.Database, "mongodb.database", "MONGODB_DATABASENAME")
+	if c.MongoDB.User != "" {
+		mandatory(c.MongoDB.Password, "mongodb.password", "MONGODB_PASSWORD")
+	}
+	mandatory(c.Redis.Host, "redis.host", "REDIS_HOST")
+	mandatory(c.Redis.Port, "redis.port", "REDIS_PORT")
+	port(c.Redis.Port, "redis.port", "REDIS_PORT")
+	atLeast(c.Operation.Timeout, 1, "operation.timeout", "OPERATION_TIMEOUT")
+	atLeast(c.Operation.Retries, 0, "operation.retries", "OPERATION_RETRIES")
+	atLeast(c.Cache.TTL, 0, "cache.ttl", "CACHE_TTL")
+	atLeast(c.Purge.RetentionDays, 0, "purge.retentiondays", "PURGE_RETENTION_DAYS")
+	atLeast(c.Purge.Interval, 1, "purge.interval", "PURGE_INTERVAL")
+	mandatory(c.Audit.Collection, "audit.collection", "AUDIT_COLLECTION")
+	mandatory(c.Webhooks.Collection, "webhooks.collection", "WEBHOOK_COLLECTION")
+	atLeast(c.Webhooks.Workers, 1, "webhooks.workers", "WEBHOOK_WORKERS")
+	atLeast(c.Webhooks.Retries, 0, "webhooks.retries", "WEBHOOK_RETRIES")
+	atLeast(c.Webhooks.Timeout, 1, "webhooks.timeout", "WEBHOOK_TIMEOUT")
+	atLeast(c.Alerts.ErrorCount, 1, "alerts.errorcount", "ALERT_5XX_COUNT")
+	atLeast(c.Alerts.ErrorWindow, 1, "alerts.errorwindow", "ALERT_5XX_WINDOW")
+	atLeast(c.Alerts.SlowQuery, 1, "alerts.slowquery", "ALERT_SLOW_QUERY")
+	atLeast(c.Alerts.Dedup, 1, "alerts.dedup", "ALERT_DEDUP")
+	atLeast(c.Alerts.RateLimit, 1, "alerts.ratelimit", "ALERT_RATE_LIMIT")
+	atLeast(c.Alerts.HealthInterval, 1, "alerts.healthinterval", "ALERT_HEALTH_INTERVAL")
+	link(c.Alerts.SlackURL, "alerts.slackurl", "ALERT_SLACK_URL")
+	link(c.Alerts.WebhookURL, "alerts.webhookurl", "ALERT_WEBHOOK_URL")
+	if !c.Auth.Disabled && c.Auth.Secret == "" && c.Auth.JWKSFile == "" {
+		errs = append(errs, errors.New("auth.secret (JWT_SECRET) or auth.jwksfile (JWT_JWKS_FILE) is mandatory unless auth.disabled (AUTH_DISABLED) is true"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+)
+
+const (
+	RELOAD   string = "Config reload : "
+	REDACTED string = "********"
+)
+
+// safe - private, the settings that can be changed without a restart (they're read on each use)
+func (c *Config) safe() []setting {
+	return []setting{
+		{"loglevel", &c.LogLevel},
+		{"server.shutdowntimeout", &c.Server.ShutdownTimeout},
+		{"operation.timeout", &c.Operation.Timeout},
+		{"operation.retries", &c.Operation.Retries},
+		{"cache.ttl", &c.Cache.TTL},
+		{"webhooks.retries", &c.Webhooks.Retries},
+		{"webhooks.timeout", &c.Webhooks.Timeout},
+		{"alerts.errorcount", &c.Alerts.ErrorCount},
+		{"alerts.errorwindow", &c.Alerts.ErrorWindow},
+		{"alerts.slowquery", &c.Alerts.SlowQuery},
+		{"alerts.dedup", &c.Alerts.Dedup},
+		{"alerts.ratelimit", &c.Alerts.RateLimit},
+	}
+}
+
+// Apply - a copy of the config with the safe settings of next, returns the names of the settings that changed
+// and of the sections that also changed but need a restart (they're not applied)
+func (c *Config) Apply(next *Config) (*Config, []string, []string) {
+	var changed, restart []string
+	merged := *c
+	cur, upd := merged.safe(), next.safe()
+	for x := range cur {
+		val, nval := reflect.ValueOf(cur[x].val).Elem(), reflect.ValueOf(upd[x].val).Elem()
+		if !reflect.DeepEqual(val.Interface(), nval.Interface()) {
+			val.Set(nval)
+			changed = append(changed, cur[x].name)
+		}
+	}
+	m, n := reflect.ValueOf(merged), reflect.ValueOf(*next)
+	for x := 0; x < m.NumField(); x++ {
+		if !reflect.DeepEqual(m.Field(x).Interface(), n.Field(x).Interface()) {
+			restart = append(restart, strings.Split(m.Type().Field(x).Tag.Get("json"), ",")[0])
+		}
+	}
+	return &merged, changed, restart
+}
+
+// Redacted - a copy of the config without the passwords, secrets and keys (the credentials of the mongodb uri and
+// the path of the alert urls are redacted too)
+func (c *Config) Redacted() *Config {
+	r := *c
+	for _, val := range []*string{&r.MongoDB.Password, &r.Redis.Password, &r.Auth.Secret, &r.Encryption.Key} {
+		if *val != "" {
+			*val = REDACTED
+		}
+	}
+	r.MongoDB.URI = redactCredentials(r.MongoDB.URI)
+	r.Alerts.SlackURL = redactPath(r.Alerts.SlackURL)
+	r.Alerts.WebhookURL = redactPath(r.Alerts.WebhookURL)
+	return &r
+}
+
+// redactCredentials - private, replaces the password of a connection string (it can have several hosts)
+func redactCredentials(uri string) string {
+	i := strings.Index(uri, "://")
+	if i < 0 {
+		return uri
+	}
+	rest := uri[i+3:]
+	at := strings.LastIndex(strings.SplitN(rest, "/", 2)[0], "@")
+	if at < 0 {
+		return uri
+	}
+	user := strings.SplitN(rest[:at], ":", 2)[0]
+	return uri[:i+3] + user + ":" + REDACTED + rest[at:]
+}
+
+// redactPath - private, the webhook urls carry their token in the path
+func redactPath(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || link == "" {
+		return link
+	}
+	return u.Scheme + "://" + u.Host + "/" + REDACTED
+}
+
+// Reloader - reloads the config file on SIGHUP or when its content changes, the safe settings are applied to the
+// current config and passed to the hooks, an invalid file is logged and the current config is kept
+type Reloader struct {
+	file  string
+	l     *logging.Logger
+	hooks []func(*Config)
+	mu    sync.Mutex
+	cur   *Config
+	sum   [sha256.Size]byte
+}
+
+// NewReloader - a reloader of the file the config was loaded from
+func NewReloader(file string, c *Config, l *logging.Logger, hooks ...func(*Config)) *Reloader {
+	r := &Reloader{file: file, l: l, hooks: hooks, cur: c}
+	r.sum, _ = checksum(file)
+	return r
+}
+
+// checksum - private, the sha256 of the file content
+func checksum(file string) ([sha256.Size]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// Config - the current config
+func (r *Reloader) Config() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cur
+}
+
+// Reload - loads the file (and the envars, they still take precedence), applies its safe settings and calls the
+// hooks with the new config, returns the names of the settings that changed
+func (r *Reloader) Reload() ([]string, error) {
+	next, err := Load(r.file)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cfg, changed, restart := r.cur.Apply(next)
+	if len(restart) > 0 {
+		r.l.Warn(fmt.Sprintf(RELOAD+"%s changed, a restart is needed to apply them", strings.Join(restart, ", ")))
+	}
+	if len(changed) == 0 {
+		r.l.Info(RELOAD + "no changes to apply")
+		return nil, nil
+	}
+	r.cur = cfg
+	for _, hook := range r.hooks {
+		hook(cfg)
+	}
+	r.l.Info(fmt.Sprintf(RELOAD+"%s applied", strings.Join(changed, ", ")))
+	return changed, nil
+}
+
+// reload - private, Reload logging the errors
+func (r *Reloader) reload() {
+	if _, err := r.Reload(); err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			r.l.Error(RELOAD + line)
+		}
+	}
+}
+
+// Run - reloads on SIGHUP and, checking every interval, when the content of the file changes (0 only reloads
+// on SIGHUP) until the ctx is done
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	var tick <-chan time.Time
+	if interval > 0 && r.file != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.l.Info(RELOAD + "SIGHUP received")
+			r.reload()
+		case <-tick:
+			// a missing file (i.e. while a configmap is being updated) is checked again on the next tick
+			sum, err := checksum(r.file)
+			if err != nil || sum == r.sum {
+				continue
+			}
+			r.sum = sum
+			r.l.Info(RELOAD + r.file + " changed")
+			r.reload()
+		}
+	}
+}
@@ -3,210 +3,335 @@
 package connectors
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
-	"github.com/globalsign/mgo/bson"
-	"github.com/microlib/simple"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 // This file is used for live connections and is included in the build but excluded for testing
 // It uises the header directive // +build !test
 // Used with the -tags=test flag when testing
 
+// FAKENOTFOUND - the fake collection reports mongo.ErrNoDocuments for this id
+const FAKENOTFOUND string = "5cc042307ccc69ada8930000"
+
+var (
+	m map[string]string
+)
+
+// FakeRedis - Down fails the ping
 type FakeRedis struct {
+	Down bool
+}
+
+// ClientInterface is an interface to access to the mongo.Client struct.
+type ClientInterface interface {
+	Database(name string, opts ...*options.DatabaseOptions) DataLayer
+	Disconnect(ctx context.Context) error
+	Ping(ctx context.Context, rp *readpref.ReadPref) error
 }
 
+// DataLayer is an interface to access to the mongo.Database struct.
 type DataLayer interface {
-	C(name string) Collection
+	Collection(name string, opts ...*options.CollectionOptions) Collection
 }
 
-// Collection is an interface to access to the collection struct.
+// Collection is an interface to access to the mongo.Collection struct.
 type Collection interface {
-	Find(query interface{}) Query
-	FindId(query interface{}) Query
-	Count() (n int, err error)
-	Insert(docs ...interface{}) error
-	Remove(selector interface{}) error
-	Update(selector interface{}, update interface{}) error
-	EnsureIndex(index interface{}) error
+	InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error)
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+	Indexes() IndexView
 }
 
-type Query interface {
-	All(result interface{}) error
-	One(result interface{}) error
-	Sort(field interface{}) Query
-	Count() (int, error)
-	Limit(val int) Query
-	Skip(val int) Query
-	Iter() FakeIter
+// IndexView is an interface to access to the mongo.IndexView struct.
+type IndexView interface {
+	CreateOne(ctx context.Context, model mongo.IndexModel, opts ...*options.CreateIndexesOptions) (string, error)
 }
 
-type Iter interface {
-	Next(data interface{}) bool
-	Err() error
-	Close()
+// FakeClient satisfies ClientInterface and act as a mock of *mongo.Client, Down fails the ping.
+type FakeClient struct {
+	Down bool
 }
 
-// Session is an interface to access to the Session struct.
-type SessionInterface interface {
-	DB(name string) DataLayer
-	Close()
-	Clone() SessionInterface
+// NewFakeClient mock mongo.Connect.
+func NewFakeClient() ClientInterface {
+	return FakeClient{}
 }
 
-// FakeSession satisfies Session and act as a mock of *mgo.session.
-type FakeSession struct{}
-
-// NewFakeSession mock NewSession.
-func NewFakeSession() SessionInterface {
-	return FakeSession{}
+// Ping fakes mongo.Client.Ping().
+func (fc FakeClient) Ping(ctx context.Context, rp *readpref.ReadPref) error {
+	if fc.Down {
+		return errors.New("server selection error: no reachable servers")
+	}
+	return nil
 }
 
-func (fs FakeSession) Clone() SessionInterface {
-	return FakeSession{}
+// Database fakes mongo.Client.Database().
+func (fc FakeClient) Database(name string, opts ...*options.DatabaseOptions) DataLayer {
+	return FakeDatabase{}
 }
 
-// Close fakes mgo.Session.Close().
-func (fs FakeSession) Close() {}
-
-// DB fakes mgo.Session.DB().
-func (fs FakeSession) DB(name string) DataLayer {
-	fakeDatabase := FakeDatabase{}
-	return fakeDatabase
+// Disconnect fakes mongo.Client.Disconnect().
+func (fc FakeClient) Disconnect(ctx context.Context) error {
+	return nil
 }
 
 // FakeDatabase satisfies DataLayer and act as a mock.
 type FakeDatabase struct{}
 
-// C fakes mgo.Database(name).Collection(name).
-func (db FakeDatabase) C(name string) Collection {
+// Collection fakes mongo.Database.Collection().
+func (db FakeDatabase) Collection(name string, opts ...*options.CollectionOptions) Collection {
 	return FakeCollection{Name: name}
 }
 
-// FakeCollection satisfies Collection and act as a mock.
+// FakeCollection satisfies Collection and act as a mock, documents with the metainfo "ERROR" fail
+// and the queries fail with the ctx error once the ctx is done.
 type FakeCollection struct {
 	Name string
-	Err  bool
 }
 
-// Find fake.
-func (fc FakeCollection) Find(query interface{}) Query {
-	fq := FakeQuery{Name: fc.Name}
-	return fq
+// fakeAudit - the audit entries written to the fake audit collection
+var fakeAudit []schema.AuditEntry
+
+// fakeStored - the documents inserted in the fake collections, returned by the fake list when fakeList is set
+var (
+	fakeStored []interface{}
+	fakeList   bool
+)
+
+// fakeDocument - the document returned by the fake queries
+func fakeDocument() schema.SchemaInterface {
+	id, _ := primitive.ObjectIDFromHex("5cc042307ccc69ada893144c")
+	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test.com"}
+	return schema.SchemaInterface{ID: id, LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
 }
 
-// Find fake.
-func (fc FakeCollection) FindId(query interface{}) Query {
-	fq := FakeQuery{Name: fc.Name}
-	return fq
+// fakeNotFound checks if the filter selects the FAKENOTFOUND id
+func fakeNotFound(filter interface{}) bool {
+	q, ok := filter.(bson.M)
+	if !ok {
+		return false
+	}
+	id, ok := q["_id"].(primitive.ObjectID)
+	return ok && id.Hex() == FAKENOTFOUND
 }
 
-// Count fake.
-func (fc FakeCollection) Count() (n int, err error) {
-	return 10, nil
+// fakeError checks if the document has the metainfo "ERROR"
+func fakeError(doc interface{}) bool {
+	switch d := doc.(type) {
+	case *schema.SchemaInterface:
+		return d.MetaInfo == "ERROR"
+	case schema.SchemaInterface:
+		return d.MetaInfo == "ERROR"
+	}
+	return false
 }
 
-// Insert fake.
-func (fc FakeCollection) Insert(docs ...interface{}) error {
-	var s **schema.SchemaInterface
-	for _, x := range docs {
-		s = x.(**schema.SchemaInterface)
+// InsertOne fake.
+func (fc FakeCollection) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
 	}
-	data := **s
-	if data.MetaInfo == "ERROR" {
-		return errors.New("Forced Error")
+	if fakeError(document) {
+		return nil, errors.New("Forced Error")
 	}
-	return nil
+	if d, ok := document.(*schema.SchemaInterface); ok {
+		fakeStored = append(fakeStored, *d)
+	}
+	return &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, nil
 }
 
-// Remove fake.
-func (fc FakeCollection) Remove(selector interface{}) error {
-	return nil
+// InsertMany fake, the audit entries are kept in fakeAudit.
+func (fc FakeCollection) InsertMany(ctx context.Context, documents []interface{}, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	res := &mongo.InsertManyResult{}
+	for _, doc := range documents {
+		if entry, ok := doc.(schema.AuditEntry); ok {
+			fakeAudit = append(fakeAudit, entry)
+		}
+		res.InsertedIDs = append(res.InsertedIDs, primitive.NewObjectID())
+	}
+	return res, nil
 }
 
-// Update fake.
-func (fc FakeCollection) Update(selector interface{}, update interface{}) error {
-	var s schema.SchemaInterface
-	s = update.(schema.SchemaInterface)
-	if s.MetaInfo == "ERROR" {
-		return errors.New("Forced Error")
+// FindOne fake.
+func (fc FakeCollection) FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	if ctx.Err() != nil {
+		return mongo.NewSingleResultFromDocument(bson.M{}, ctx.Err(), nil)
 	}
-	return nil
+	if fakeNotFound(filter) {
+		return mongo.NewSingleResultFromDocument(bson.M{}, mongo.ErrNoDocuments, nil)
+	}
+	return mongo.NewSingleResultFromDocument(fakeDocument(), nil, nil)
 }
 
-// EnsurIndex fake.
-func (fc FakeCollection) EnsureIndex(index interface{}) error {
-	return nil
+// Find fake.
+func (fc FakeCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if fc.Name == config.AUDITCOLLECTION {
+		var docs []interface{}
+		q, _ := filter.(bson.M)
+		for x := len(fakeAudit) - 1; x >= 0; x-- {
+			if fakeAudit[x].DocumentID == q["documentid"] {
+				docs = append(docs, fakeAudit[x])
+			}
+		}
+		return mongo.NewCursorFromDocuments(docs, nil, nil)
+	}
+	// the lookup of the bulk delete ids, all but FAKENOTFOUND exist
+	if q, ok := filter.(bson.M); ok {
+		id, _ := q["_id"].(bson.M)
+		if in, ok := id["$in"].([]primitive.ObjectID); ok {
+			var docs []interface{}
+			for _, id := range in {
+				if id.Hex() != FAKENOTFOUND {
+					docs = append(docs, bson.M{"_id": id})
+				}
+			}
+			return mongo.NewCursorFromDocuments(docs, nil, nil)
+		}
+	}
+	if fakeList {
+		return mongo.NewCursorFromDocuments(fakeStored, nil, nil)
+	}
+	return mongo.NewCursorFromDocuments([]interface{}{fakeDocument()}, nil, nil)
 }
 
-// FakeQuery satisfies Query and act as a mock.
-type FakeQuery struct {
-	Name string
+// ReplaceOne fake, the metainfo "CHANGED" simulates a document updated by somebody else.
+func (fc FakeCollection) ReplaceOne(ctx context.Context, filter interface{}, replacement interface{}, opts ...*options.ReplaceOptions) (*mongo.UpdateResult, error) {
+	if fakeError(replacement) {
+		return nil, errors.New("Forced Error")
+	}
+	if d, ok := replacement.(schema.SchemaInterface); ok && d.MetaInfo == "CHANGED" {
+		return &mongo.UpdateResult{}, nil
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
 }
 
-// All fake.
-func (fq FakeQuery) All(result interface{}) error {
-	return nil
+// UpdateOne fake (soft delete and restore).
+func (fc FakeCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if fakeNotFound(filter) {
+		return &mongo.UpdateResult{}, nil
+	}
+	return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
 }
 
-// One fake.
-func (fq FakeQuery) One(result interface{}) error {
-	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test.com"}
-	*result.(*schema.SchemaInterface) = schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
-	return nil
+// DeleteMany fake (purge).
+func (fc FakeCollection) DeleteMany(ctx context.Context, filter interface{}, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return &mongo.DeleteResult{DeletedCount: 2}, nil
 }
 
-// Distinct fake.
-func (fq FakeQuery) Distinct(field string, result interface{}) error {
-	return nil
+// CountDocuments fake.
+func (fc FakeCollection) CountDocuments(ctx context.Context, filter interface{}, opts ...*options.CountOptions) (int64, error) {
+	return 10, nil
 }
 
-// Distinct fake.
-func (fq FakeQuery) Count() (int, error) {
-	return 10, nil
+// BulkWrite fake, a document with the metainfo "ERROR" fails the whole bulk write.
+func (fc FakeCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	for _, model := range models {
+		switch wm := model.(type) {
+		case *mongo.InsertOneModel:
+			if fakeError(wm.Document) {
+				return nil, errors.New("Forced Error")
+			}
+		case *mongo.ReplaceOneModel:
+			if fakeError(wm.Replacement) {
+				return nil, errors.New("Forced Error")
+			}
+		}
+	}
+	return &mongo.BulkWriteResult{}, nil
 }
 
-type FakeIter struct {
+// Indexes fake.
+func (fc FakeCollection) Indexes() IndexView {
+	return FakeIndexView{}
 }
 
-func (f FakeQuery) Iter() FakeIter {
-	fio := FakeIter{}
-	return fio
+// FakeIndexView satisfies IndexView and act as a mock.
+type FakeIndexView struct{}
+
+// CreateOne fake.
+func (fi FakeIndexView) CreateOne(ctx context.Context, model mongo.IndexModel, opts ...*options.CreateIndexesOptions) (string, error) {
+	return "", nil
 }
 
-// Sort fake.
-func (fq FakeQuery) Sort(field interface{}) Query {
-	return fq
+type Connections struct {
+	Http  *http.Client
+	Redis *FakeRedis
+	l     *logging.Logger
+	DB    ClientInterface
+	Name  string
+	cfg   *config.Config
+	cmu   sync.RWMutex
 }
 
-// Limit fake.
-func (fq FakeQuery) Limit(val int) Query {
-	return fq
+// fake redis Get
+func (r *Connections) Get(ctx context.Context, key string) (string, error) {
+	if key == "error" {
+		return "", errors.New("Get method failed")
+	}
+	return m[key], nil
 }
 
-func (fq FakeQuery) Skip(val int) Query {
-	return fq
+// fake redis Set
+func (r *Connections) Set(ctx context.Context, key string, value string, expr time.Duration) (string, error) {
+	if key == "error" {
+		return "", errors.New("Set method failed")
+	}
+	m[key] = value
+	return value, nil
 }
 
-func (fi FakeIter) Next(data interface{}) bool {
-	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test.com"}
-	*data.(*schema.SchemaInterface) = schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
-	return false
+// fake redis Close
+func (r *Connections) Del(ctx context.Context, key string) error {
+	if key == "error" {
+		return errors.New("Del method failed")
+	}
+	delete(m, key)
+	return nil
 }
 
-func (fi FakeIter) Err() error {
+func (r *FakeRedis) Close() error {
 	return nil
 }
 
-func (fi FakeIter) Close() {
+// fake redis Ping
+func (r *Connections) redisPing(ctx context.Context) error {
+	if r.Redis.Down {
+		return errors.New("dial tcp: connection refused")
+	}
+	return nil
 }
 
-type Connections struct {
-	Http  *http.Client
-	Redis *FakeRedis
-	l     *simple.Logger
-	DB    SessionInterface
-	Name  string
+func (r *Connections) Close() error {
+	return nil
 }
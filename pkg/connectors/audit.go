@@ -0,0 +1,230 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/auth"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DBHISTORY string = "DBHistory : "
+	// the audit entries of all the resources are kept in one collection (audit.collection)
+	AUDITINDEX   string = "_history"
+	ANONYMOUS    string = "anonymous"
+	CUSTOMPREFIX string = "custom."
+)
+
+// historyIndex - the index of the audit entries of a document, newest first
+func historyIndex(collection string) mongo.IndexModel {
+	keys := bson.D{{Key: "resource", Value: 1}, {Key: "documentid", Value: 1}, {Key: "timestamp", Value: -1}}
+	return mongo.IndexModel{Keys: keys, Options: options.Index().SetName(collection + AUDITINDEX)}
+}
+
+// actor - private, the subject of the verified claims (the token sub or the api key name)
+func actor(ctx context.Context) string {
+	claims, _ := auth.FromContext(ctx)
+	if sub := claims.Subject(); sub != "" {
+		return sub
+	}
+	return ANONYMOUS
+}
+
+// flatten - private, the document fields by dotted name (i.e. custom.email), the _id, the lastupdate
+// (it's the timestamp of the entry) and the empty fields are left out
+func flatten(doc *schema.SchemaInterface) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if doc == nil {
+		return fields
+	}
+	var m map[string]interface{}
+	b, _ := json.Marshal(doc)
+	json.Unmarshal(b, &m)
+	delete(m, "_id")
+	delete(m, "lastupdate")
+	flattenInto(fields, "", m)
+	return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			flattenInto(fields, prefix+k+".", val)
+		case nil:
+		case string:
+			if val != "" {
+				fields[prefix+k] = val
+			}
+		default:
+			fields[prefix+k] = val
+		}
+	}
+}
+
+// diff - private, the fields that changed between the flattened documents sorted by name
+func diff(before, after map[string]interface{}) []schema.Change {
+	var changes []schema.Change
+	for k, v := range before {
+		if !reflect.DeepEqual(v, after[k]) {
+			changes = append(changes, schema.Change{Field: k, Before: v, After: after[k]})
+		}
+	}
+	for k, v := range after {
+		if _, ok := before[k]; !ok {
+			changes = append(changes, schema.Change{Field: k, After: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// sealChanges - private, encrypts the values of the encrypted customer fields so that the audit
+// collection doesn't hold the pii in clear
+func sealChanges(changes []schema.Change) error {
+	ci := getCipher()
+	if ci == nil {
+		return nil
+	}
+	for x := range changes {
+		name := strings.TrimPrefix(changes[x].Field, CUSTOMPREFIX)
+		if name == changes[x].Field || !ci.Encrypted(name) {
+			continue
+		}
+		for _, val := range []*interface{}{&changes[x].Before, &changes[x].After} {
+			if s, ok := (*val).(string); ok {
+				enc, err := ci.Encrypt(name, s)
+				if err != nil {
+					return err
+				}
+				*val = enc
+			}
+		}
+	}
+	return nil
+}
+
+// openChanges - private, decrypts the values sealed by sealChanges
+func openChanges(changes []schema.Change) error {
+	ci := getCipher()
+	if ci == nil {
+		return nil
+	}
+	for x := range changes {
+		name := strings.TrimPrefix(changes[x].Field, CUSTOMPREFIX)
+		if name == changes[x].Field || !ci.Encrypted(name) {
+			continue
+		}
+		for _, val := range []*interface{}{&changes[x].Before, &changes[x].After} {
+			if s, ok := (*val).(string); ok && ci.Sealed(s) {
+				dec, err := ci.Decrypt(name, s)
+				if err != nil {
+					return err
+				}
+				*val = dec
+			}
+		}
+	}
+	return nil
+}
+
+// auditEntry - private, the entry of a mutation made by the caller of the ctx, op is one of the DBINSERT... prefixes
+func auditEntry(ctx context.Context, op string, resource string, id string, changes []schema.Change) schema.AuditEntry {
+	return schema.AuditEntry{
+		Resource:   resource,
+		DocumentID: id,
+		Operation:  strings.TrimSuffix(op, " : "),
+		Actor:      actor(ctx),
+		RequestID:  logging.RequestID(ctx),
+		Timestamp:  time.Now().UnixNano(),
+		Changes:    changes,
+	}
+}
+
+// mutation - private, a change made to a document, doc is the new document (nil for the deletes and restores)
+type mutation struct {
+	id      string
+	changes []schema.Change
+	doc     *schema.SchemaInterface
+}
+
+// record - private, writes the audit entries of the mutations made by an operation and publishes their change events
+func (r *Connections) record(ctx context.Context, log *opLog, op string, resource string, mutations ...mutation) {
+	entries := make([]schema.AuditEntry, 0, len(mutations))
+	for _, m := range mutations {
+		entries = append(entries, auditEntry(ctx, op, resource, m.id, m.changes))
+	}
+	r.audit(ctx, log, entries...)
+	publish(ctx, log, op, resource, mutations...)
+}
+
+// audit - private, writes the audit entries of the mutations that succeeded, the mutation is already
+// done so a failed write is only logged
+func (r *Connections) audit(ctx context.Context, log *opLog, entries ...schema.AuditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	docs := make([]interface{}, 0, len(entries))
+	for x := range entries {
+		if err := sealChanges(entries[x].Changes); err != nil {
+			log.Error("audit entry of %s not written %v", entries[x].DocumentID, err)
+			continue
+		}
+		docs = append(docs, entries[x])
+	}
+	if len(docs) == 0 {
+		return
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(r.Config().Audit.Collection)
+	if _, err := c.InsertMany(ctx, docs); err != nil {
+		log.Error("%d audit entries not written %v", len(docs), dbError(err))
+	}
+}
+
+// DBHistory lists the audit entries of a document newest first, the range is From (skip) and To
+func (r *Connections) DBHistory(ctx context.Context, resource string, id string, lr *schema.ListRange) ([]schema.AuditEntry, error) {
+	var history []schema.AuditEntry
+	log := r.log(ctx, DBHISTORY, resource, id)
+	if _, err := collection(resource); err != nil {
+		return history, log.done(err)
+	}
+	if _, err := objectID(id); err != nil {
+		return history, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(r.Config().Audit.Collection)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).SetSkip(int64(lr.From))
+	if lr.To > lr.From {
+		opts.SetLimit(int64(lr.To - lr.From))
+	}
+	query := bson.M{"resource": resource, "documentid": id}
+	e := r.retry(ctx, log, func() error {
+		history = nil
+		cursor, err := c.Find(ctx, query, opts)
+		if err != nil {
+			return dbError(err)
+		}
+		defer cursor.Close(ctx)
+		return dbError(cursor.All(ctx, &history))
+	})
+	if e != nil {
+		return history, log.done(e)
+	}
+	for x := range history {
+		if err := openChanges(history[x].Changes); err != nil {
+			return nil, log.done(err)
+		}
+	}
+	log.Debug("%d audit entries", len(history))
+	return history, log.done(nil)
+}
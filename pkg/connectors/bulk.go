@@ -0,0 +1,285 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DBBULKINSERT string = "DBBulkInsert : "
+	DBBULKUPSERT string = "DBBulkUpsert : "
+	DBBULKDELETE string = "DBBulkDelete : "
+)
+
+// bulkWriter - private, the collection bulk write (the fake collection implements it for testing)
+type bulkWriter interface {
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// bulkItems - private, keeps the result of each item and maps the queued bulk operations back to the items
+// mutations is the change made by each queued item (audited and published once the item succeeds)
+type bulkItems struct {
+	results   []schema.BulkResult
+	ops       []int
+	models    []mongo.WriteModel
+	mutations []mutation
+}
+
+func newBulkItems(n int) *bulkItems {
+	results := make([]schema.BulkResult, n)
+	for x := range results {
+		results[x].Index = x
+	}
+	return &bulkItems{results: results, mutations: make([]mutation, n)}
+}
+
+// fail - the item is not sent to the database
+func (b *bulkItems) fail(index int, err error) {
+	b.results[index].Err = err
+}
+
+// queue - the item is the next operation of the bulk
+func (b *bulkItems) queue(index int, id string, model mongo.WriteModel, m mutation) {
+	b.results[index].ID = id
+	b.ops = append(b.ops, index)
+	b.models = append(b.models, model)
+	m.id = id
+	b.mutations[index] = m
+}
+
+// succeeded - the mutations of the queued items that succeeded
+func (b *bulkItems) succeeded() []mutation {
+	var list []mutation
+	for _, index := range b.ops {
+		if b.results[index].Err == nil {
+			list = append(list, b.mutations[index])
+		}
+	}
+	return list
+}
+
+// write - runs the queued operations unordered so that a failed item doesn't stop the others
+func (b *bulkItems) write(ctx context.Context, c bulkWriter) error {
+	if len(b.models) == 0 {
+		return nil
+	}
+	_, err := c.BulkWrite(ctx, b.models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// done - applies the bulk write error, a write error fails only its own item (a write concern error
+// fails all the queued items), any other error fails the whole request
+func (b *bulkItems) done(err error) ([]schema.BulkResult, error) {
+	if err == nil {
+		return b.results, nil
+	}
+	var berr mongo.BulkWriteException
+	if !errors.As(err, &berr) {
+		return b.results, dbError(err)
+	}
+	for _, we := range berr.WriteErrors {
+		if we.Index >= 0 && we.Index < len(b.ops) {
+			b.results[b.ops[we.Index]].Err = dbError(we.WriteError)
+		}
+	}
+	if berr.WriteConcernError != nil {
+		for _, index := range b.ops {
+			if b.results[index].Err == nil {
+				b.results[index].Err = fmt.Errorf("%w : %v", ErrUnavailable, berr.WriteConcernError)
+			}
+		}
+	}
+	return b.results, nil
+}
+
+// bulkDocument - private, unmarshals a bulk item, an item that can't be unmarshalled is not valid
+func bulkDocument(resource string, item json.RawMessage) (*schema.SchemaInterface, error) {
+	var data *schema.SchemaInterface
+	if err := decode(resource, item, &data); err != nil {
+		if errors.Is(err, ErrValidation) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w : %v", ErrValidation, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w : empty document", ErrValidation)
+	}
+	return data, nil
+}
+
+// DBBulkInsert inserts all the documents, the ids of the new documents are returned in the results
+func (r *Connections) DBBulkInsert(ctx context.Context, resource string, items []json.RawMessage) ([]schema.BulkResult, error) {
+	log := r.log(ctx, DBBULKINSERT, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return nil, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	bi := newBulkItems(len(items))
+	for x, item := range items {
+		data, e := bulkDocument(resource, item)
+		if e != nil {
+			bi.fail(x, e)
+			continue
+		}
+		if data.ID.IsZero() {
+			data.ID = primitive.NewObjectID()
+		}
+		data.LastUpdate = time.Now().UnixNano()
+		data.DeletedAt = 0
+		doc := *data
+		m := mutation{changes: diff(nil, flatten(data)), doc: &doc}
+		if e = encryptCustom(&data.Custom); e != nil {
+			bi.fail(x, e)
+			continue
+		}
+		bi.queue(x, data.ID.Hex(), mongo.NewInsertOneModel().SetDocument(data), m)
+	}
+	err = bi.write(ctx, c)
+	log.Debug("%d of %d items queued", len(bi.ops), len(items))
+	results, err := bi.done(err)
+	if err == nil {
+		r.record(ctx, log, DBBULKINSERT, resource, bi.succeeded()...)
+	}
+	return results, log.done(err)
+}
+
+// DBBulkUpsert replaces (or inserts if they don't exist) all the documents, documents without an _id are inserted
+// and soft deleted documents are restored (the documents are complete, they're validated without the partial option)
+// Unlike DBUpdate the documents are not merged and the version is not checked (the audit entries only have the new values)
+func (r *Connections) DBBulkUpsert(ctx context.Context, resource string, items []json.RawMessage) ([]schema.BulkResult, error) {
+	log := r.log(ctx, DBBULKUPSERT, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return nil, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	bi := newBulkItems(len(items))
+	for x, item := range items {
+		data, e := bulkDocument(resource, item)
+		if e != nil {
+			bi.fail(x, e)
+			continue
+		}
+		if data.ID.IsZero() {
+			data.ID = primitive.NewObjectID()
+		}
+		data.LastUpdate = time.Now().UnixNano()
+		data.DeletedAt = 0
+		doc := *data
+		m := mutation{changes: diff(nil, flatten(data)), doc: &doc}
+		if e = encryptCustom(&data.Custom); e != nil {
+			bi.fail(x, e)
+			continue
+		}
+		model := mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": data.ID}).SetReplacement(data).SetUpsert(true)
+		bi.queue(x, data.ID.Hex(), model, m)
+	}
+	err = bi.write(ctx, c)
+	for _, index := range bi.ops {
+		r.cacheDel(ctx, resource, bi.results[index].ID)
+	}
+	log.Debug("%d of %d items queued", len(bi.ops), len(items))
+	results, err := bi.done(err)
+	if err == nil {
+		r.record(ctx, log, DBBULKUPSERT, resource, bi.succeeded()...)
+	}
+	return results, log.done(err)
+}
+
+// DBBulkDelete soft deletes all the documents with the given ids, ids that don't exist or are already deleted
+// are reported as not found and the repeats of an id as a conflict (they're neither audited nor published)
+func (r *Connections) DBBulkDelete(ctx context.Context, resource string, ids []string) ([]schema.BulkResult, error) {
+	log := r.log(ctx, DBBULKDELETE, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return nil, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	bi := newBulkItems(len(ids))
+	oids := make([]primitive.ObjectID, len(ids))
+	var valid []primitive.ObjectID
+	for x, id := range ids {
+		oid, e := objectID(id)
+		if e != nil {
+			bi.fail(x, e)
+			continue
+		}
+		oids[x] = oid
+		valid = append(valid, oid)
+	}
+	// an update that matches nothing isn't a write error, so the ids that can be deleted are looked up first
+	found := map[primitive.ObjectID]bool{}
+	if len(valid) > 0 {
+		err = r.retry(ctx, log, func() error {
+			cur, err := c.Find(ctx, bson.M{"_id": bson.M{"$in": valid}, DELETEDAT: notDeleted}, options.Find().SetProjection(bson.M{"_id": 1}))
+			if err != nil {
+				return dbError(err)
+			}
+			defer cur.Close(ctx)
+			for cur.Next(ctx) {
+				var doc struct {
+					ID primitive.ObjectID `bson:"_id"`
+				}
+				if err = cur.Decode(&doc); err != nil {
+					return dbError(err)
+				}
+				found[doc.ID] = true
+			}
+			return dbError(cur.Err())
+		})
+		if err != nil {
+			return nil, log.done(err)
+		}
+	}
+
+	now := time.Now().UnixNano()
+	queued := map[primitive.ObjectID]bool{}
+	for x, id := range ids {
+		if bi.results[x].Err != nil {
+			continue
+		}
+		if !found[oids[x]] {
+			bi.results[x].ID = id
+			bi.fail(x, dbError(mongo.ErrNoDocuments))
+			continue
+		}
+		if queued[oids[x]] {
+			bi.results[x].ID = id
+			bi.fail(x, fmt.Errorf("%w : document %s is already in the request", ErrConflict, id))
+			continue
+		}
+		queued[oids[x]] = true
+		update := bson.M{"$set": bson.M{DELETEDAT: now, "lastupdate": now}}
+		model := mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": oids[x], DELETEDAT: notDeleted}).SetUpdate(update)
+		bi.queue(x, id, model, mutation{changes: []schema.Change{{Field: DELETEDAT, After: now}}})
+	}
+	err = bi.write(ctx, c)
+	for _, index := range bi.ops {
+		r.cacheDel(ctx, resource, bi.results[index].ID)
+	}
+	log.Debug("%d of %d items queued", len(bi.ops), len(ids))
+	results, err := bi.done(err)
+	if err == nil {
+		r.record(ctx, log, DBBULKDELETE, resource, bi.succeeded()...)
+	}
+	return results, log.done(err)
+}
@@ -0,0 +1,73 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+const (
+	CACHE    string = "Cache : "
+	CACHEKEY string = "dbservice"
+)
+
+// cacheTTL - private, the DBGet cache expiry (cache.ttl seconds), 0 disables the cache
+func (r *Connections) cacheTTL() time.Duration {
+	return time.Duration(r.Config().Cache.TTL) * time.Second
+}
+
+// cacheKey - private, documents are cached per resource and ObjectId
+func cacheKey(resource string, id string) string {
+	return CACHEKEY + ":" + resource + ":" + id
+}
+
+// cacheGet - private, looks up a document in redis, a redis error is treated as a miss
+func (r *Connections) cacheGet(ctx context.Context, resource string, id string) (schema.SchemaInterface, bool) {
+	var data schema.SchemaInterface
+	if r.cacheTTL() == 0 {
+		return data, false
+	}
+	log := r.log(ctx, CACHE, resource, id)
+	key := cacheKey(resource, id)
+	val, err := r.Get(ctx, key)
+	if err != nil || val == "" {
+		log.Debug("miss %s", key)
+		return data, false
+	}
+	if err = json.Unmarshal([]byte(val), &data); err != nil {
+		log.Error("corrupt entry %s %v", key, err)
+		return data, false
+	}
+	log.Debug("hit %s", key)
+	log.Trace("data %s", val)
+	return data, true
+}
+
+// cacheSet - private, stores (or refreshes) a document in redis
+func (r *Connections) cacheSet(ctx context.Context, resource string, data schema.SchemaInterface) {
+	ttl := r.cacheTTL()
+	if ttl == 0 {
+		return
+	}
+	log := r.log(ctx, CACHE, resource, data.ID.Hex())
+	key := cacheKey(resource, data.ID.Hex())
+	b, _ := json.Marshal(data)
+	if _, err := r.Set(ctx, key, string(b), ttl); err != nil {
+		log.Error("set %s %v", key, err)
+		return
+	}
+	log.Trace("set %s", key)
+}
+
+// cacheDel - private, invalidates a cached document
+func (r *Connections) cacheDel(ctx context.Context, resource string, id string) {
+	log := r.log(ctx, CACHE, resource, id)
+	key := cacheKey(resource, id)
+	if err := r.Del(ctx, key); err != nil {
+		log.Error("del %s %v", key, err)
+		return
+	}
+	log.Trace("del %s", key)
+}
@@ -3,15 +3,19 @@
 package connectors
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
 	"net/http"
-	"os"
+	"sync"
 	"time"
 
-	"github.com/globalsign/mgo"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/metrics"
 	"github.com/go-redis/redis"
-	"github.com/microlib/simple"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 // This file is used for live connections and is included in the build but excluded for testing
@@ -19,77 +23,154 @@ import (
 // Used with the -tags=test flag when testing
 
 type Connections struct {
-	DB    *mgo.Session
-	l     *simple.Logger
+	DB    *mongo.Client
+	l     *logging.Logger
 	Http  *http.Client
 	Redis *redis.Client
 	Name  string
+	cfg   *config.Config
+	cmu   sync.RWMutex
+	stop  context.CancelFunc
 }
 
-func NewClientConnections(logger *simple.Logger) Clients {
-	var mongoDBDialInfo *mgo.DialInfo
-	// set up http object
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+const (
+	STARTUP        string        = "Startup : "
+	STARTUPBACKOFF time.Duration = time.Second
+	STARTUPMAX     time.Duration = 30 * time.Second
+)
+
+// mongoURI - the uri is used as is, otherwise the connection string is built from the host, port and replicaset
+func mongoURI(c config.MongoDB) string {
+	if c.URI != "" {
+		return c.URI
 	}
-	httpClient := &http.Client{Transport: tr}
-
-	if os.Getenv("MONGODB_REPLICASET") == "" {
-		// mongodb connection
-		mongoDBDialInfo = &mgo.DialInfo{
-			Addrs:    []string{os.Getenv("MONGODB_HOST") + ":" + os.Getenv("MONGODB_PORT")},
-			Timeout:  40 * time.Second,
-			Database: os.Getenv("MONGODB_DATABASE"),
-			Username: os.Getenv("MONGODB_USER"),
-			Password: os.Getenv("MONGODB_PASSWORD"),
-		}
-	} else {
-		mongoDBDialInfo = &mgo.DialInfo{
-			Addrs:          []string{os.Getenv("MONGODB_HOST")},
-			Timeout:        40 * time.Second,
-			Database:       os.Getenv("MONGODB_DATABASE"),
-			Username:       os.Getenv("MONGODB_USER"),
-			Password:       os.Getenv("MONGODB_PASSWORD"),
-			ReplicaSetName: os.Getenv("MONGODB_REPLICASET"),
-		}
+	if c.ReplicaSet == "" {
+		return "mongodb://" + c.Host + ":" + c.Port
+	}
+	return "mongodb://" + c.Host + "/?replicaSet=" + c.ReplicaSet
+}
+
+// NewClientConnections - the mongodb, redis and http clients of the (validated) config
+func NewClientConnections(cfg *config.Config, logger *logging.Logger) Clients {
+	// the http client posting the alerts and the webhook events, it verifies the server certificates
+	// (the timeouts are set per request)
+	httpClient := &http.Client{}
+
+	// mongodb connection, credentials in the connection string take precedence
+	opts := options.Client().ApplyURI(mongoURI(cfg.MongoDB)).
+		SetConnectTimeout(40 * time.Second).
+		SetServerSelectionTimeout(5 * time.Second).
+		SetRetryReads(true).
+		SetRetryWrites(true).
+		SetReadPreference(readpref.Primary()).
+		SetBSONOptions(&options.BSONOptions{DefaultDocumentM: true, OmitZeroStruct: true}).
+		SetMonitor(metrics.CommandMonitor()).
+		SetPoolMonitor(metrics.PoolMonitor())
+	if cfg.MongoDB.User != "" && opts.Auth == nil {
+		opts.SetAuth(options.Credential{
+			AuthSource: cfg.MongoDB.AuthSource,
+			Username:   cfg.MongoDB.User,
+			Password:   cfg.MongoDB.Password,
+		})
 	}
 
-	// database setup and init
-	ss, e := mgo.DialWithInfo(mongoDBDialInfo)
+	// database setup and init, connect doesn't wait for the servers so it only fails on a bad configuration
+	client, e := mongo.Connect(context.Background(), opts)
 	if e != nil {
 		logger.Error(fmt.Sprintf("Mongodb init %v\n", e.Error()))
 		return nil
 	}
-	ss.SetMode(mgo.Monotonic, true)
-	logger.Trace(fmt.Sprintf("Mongodb dialinfo %v\n", mongoDBDialInfo))
-	logger.Trace(fmt.Sprintf("Mongodb connection successful %v\n", ss))
+	logger.Trace(fmt.Sprintf("Mongodb hosts %v\n", opts.Hosts))
 
 	// connect to redis
 	redisClient := redis.NewClient(&redis.Options{
-		Addr:         os.Getenv("REDIS_HOST") + ":" + os.Getenv("REDIS_PORT"),
+		Addr:         cfg.Redis.Host + ":" + cfg.Redis.Port,
 		DialTimeout:  10 * time.Second,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		PoolSize:     10,
 		PoolTimeout:  30 * time.Second,
-		Password:     os.Getenv("REDIS_PASSWORD"),
+		Password:     cfg.Redis.Password,
 		DB:           0,
 	})
 
-	return &Connections{Http: httpClient, Redis: redisClient, DB: ss, Name: "LiveConnectors", l: logger}
+	metrics.RedisPool(func() (uint32, uint32) {
+		stats := redisClient.PoolStats()
+		return stats.TotalConns, stats.IdleConns
+	})
+
+	// the servers are waited for in the background, until then the service runs degraded (not ready)
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &Connections{Http: httpClient, Redis: redisClient, DB: client, Name: "LiveConnectors", l: logger, cfg: cfg, stop: cancel}
+	go conn.connect(ctx)
+	return conn
+}
+
+// connect - private, pings mongodb and redis with an exponential backoff until both respond
+// the indexes are created once mongodb is reachable, it stops when the connections are closed
+func (r *Connections) connect(ctx context.Context) {
+	indexed := false
+	for attempt := 0; ; attempt++ {
+		deps := r.Health(ctx)
+		if !indexed && deps[0].Status == UP {
+			// a missing index only affects searches so don't fail the startup
+			if err := r.DBIndex(); err != nil {
+				r.Error(STARTUP+"mongodb index creation %v\n", err)
+			}
+			indexed = true
+		}
+		if indexed && deps[1].Status == UP {
+			r.Info(STARTUP + "mongodb and redis connections successful\n")
+			return
+		}
+		delay := backoff(attempt, STARTUPBACKOFF, STARTUPMAX)
+		r.Info(STARTUP+"backends not ready, retrying in %v\n", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
 }
 
-func (r *Connections) Get(key string) (string, error) {
-	val, err := r.Redis.Get(key).Result()
+func (r *Connections) Get(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	val, err := r.Redis.WithContext(ctx).Get(key).Result()
+	if err == redis.Nil {
+		metrics.ObserveRedis("get", start, nil)
+	} else {
+		metrics.ObserveRedis("get", start, err)
+	}
 	return val, err
 }
 
-func (r *Connections) Set(key string, value string, expr time.Duration) (string, error) {
-	val, err := r.Redis.Set(key, value, expr).Result()
+func (r *Connections) Set(ctx context.Context, key string, value string, expr time.Duration) (string, error) {
+	start := time.Now()
+	val, err := r.Redis.WithContext(ctx).Set(key, value, expr).Result()
+	metrics.ObserveRedis("set", start, err)
 	return val, err
 }
 
+func (r *Connections) Del(ctx context.Context, key string) error {
+	start := time.Now()
+	err := r.Redis.WithContext(ctx).Del(key).Err()
+	metrics.ObserveRedis("del", start, err)
+	return err
+}
+
+// redisPing - private, used by the readiness check
+func (r *Connections) redisPing(ctx context.Context) error {
+	return r.Redis.WithContext(ctx).Ping().Err()
+}
+
+// Close - disconnects the mongodb client and closes the redis client
 func (r *Connections) Close() error {
-	r.Redis.Close()
-	return nil
+	r.stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := r.DB.Disconnect(ctx)
+	if e := r.Redis.Close(); err == nil {
+		err = e
+	}
+	return err
 }
@@ -1,16 +1,19 @@
 package connectors
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
-	"github.com/globalsign/mgo/bson"
 	"github.com/imdario/mergo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -21,8 +24,10 @@ const (
 	DBLIST          string = "DBList : "
 	DBGET           string = "DBGet : "
 	DBCOUNT         string = "DBCount : "
+	DBINDEX         string = "DBIndex : "
+	DBRESTORE       string = "DBRestore : "
+	DBPURGE         string = "DBPurge : "
 	DBSCHEMA        string = "customer"
-	DBSESSION       string = "Failed to clone session"
 	CONTENTTYPE     string = "Content-Type"
 	APPLICATIONJSON string = "application/json"
 	OK              string = "OK"
@@ -30,8 +35,51 @@ const (
 	TO              string = "TO"
 	SEARCH          string = "SEARCH"
 	DATABASE        string = ""
+	TEXTINDEX       string = "_text"
+	DELETEDAT       string = "deletedat"
 )
 
+// notDeleted - the filter of the documents that are not soft deleted
+var notDeleted = bson.M{"$exists": false}
+
+// textIndex - the text index over the resource text fields used by the DBList search
+func textIndex(res schema.Resource) mongo.IndexModel {
+	keys := bson.D{}
+	for _, field := range res.TextFields {
+		keys = append(keys, bson.E{Key: field, Value: "text"})
+	}
+	return mongo.IndexModel{Keys: keys, Options: options.Index().SetName(res.Collection + TEXTINDEX)}
+}
+
+// deletedIndex - the sparse index of the soft deleted documents used by the purge
+func deletedIndex(res schema.Resource) mongo.IndexModel {
+	keys := bson.D{{Key: DELETEDAT, Value: 1}}
+	return mongo.IndexModel{Keys: keys, Options: options.Index().SetName(res.Collection + "_" + DELETEDAT).SetSparse(true)}
+}
+
+// objectID - private, parses a hex ObjectId
+func objectID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return oid, ErrInvalidID
+	}
+	return oid, nil
+}
+
+// Config - the current settings, a config is never changed once set (a reload sets a new one)
+func (r *Connections) Config() *config.Config {
+	r.cmu.RLock()
+	defer r.cmu.RUnlock()
+	return r.cfg
+}
+
+// SetConfig - replaces the settings (see config.Reloader), the operations already running keep the previous ones
+func (r *Connections) SetConfig(c *config.Config) {
+	r.cmu.Lock()
+	defer r.cmu.Unlock()
+	r.cfg = c
+}
+
 func (r *Connections) Do(req *http.Request) (*http.Response, error) {
 	return r.Http.Do(req)
 }
@@ -52,139 +100,351 @@ func (r *Connections) Trace(msg string, val ...interface{}) {
 	r.l.Trace(fmt.Sprintf(msg, val...))
 }
 
+// DBIndex creates the indexes needed by the crudl operations of each registered resource (called at startup)
+func (r *Connections) DBIndex() error {
+	ctx, cancel := r.withTimeout(context.Background())
+	defer cancel()
+	for _, res := range Resources() {
+		models := []mongo.IndexModel{deletedIndex(res)}
+		if len(res.TextFields) > 0 {
+			models = append(models, textIndex(res))
+		}
+		c := r.DB.Database(r.Config().MongoDB.Database).Collection(res.Collection)
+		for _, model := range models {
+			name, err := c.Indexes().CreateOne(ctx, model)
+			if err != nil {
+				r.Error(DBINDEX+" %s %v\n", res.Collection, err)
+				return err
+			}
+			r.Debug(DBINDEX+" index %s ensured on %s\n", name, res.Collection)
+		}
+	}
+	audit := r.Config().Audit.Collection
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(audit)
+	name, err := c.Indexes().CreateOne(ctx, historyIndex(audit))
+	if err != nil {
+		r.Error(DBINDEX+" %s %v\n", audit, err)
+		return err
+	}
+	r.Debug(DBINDEX+" index %s ensured on %s\n", name, audit)
+	return nil
+}
+
+// listQuery - builds the DBList filter, a non empty search term uses the mongodb text index
+// i.e. words are or'ed, "quoted phrases" must match and a -word excludes documents
+// the email is an exact match (encrypted fields can't be searched), soft deleted documents are excluded
+// unless IncludeDeleted is set
+func listQuery(lr *schema.ListRange) bson.M {
+	query := bson.M{}
+	if !lr.IncludeDeleted {
+		query[DELETEDAT] = notDeleted
+	}
+	search := strings.TrimSpace(lr.Search)
+	if search != "" {
+		query["$text"] = bson.M{"$search": search}
+	}
+	if lr.Email != "" {
+		query["custom.email"] = emailQuery(lr.Email)
+	}
+	return query
+}
+
 // database crudl implementation
 
 // Insert
-func (r *Connections) DBInsert(body []byte) error {
+func (r *Connections) DBInsert(ctx context.Context, resource string, body []byte) error {
 	var data *schema.SchemaInterface
-	s := r.DB.Clone()
-	defer s.Close()
-	c := s.DB(os.Getenv("MONGODB_DATABASENAME")).C(DBSCHEMA)
-	e := json.Unmarshal(body, &data)
+	log := r.log(ctx, DBINSERT, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	e := decode(resource, body, &data)
 	if e != nil {
-		r.Error(DBINSERT+" %v\n", e)
-		return e
+		return log.done(dbError(e))
+	}
+	if data == nil {
+		return log.done(fmt.Errorf("%w : empty document", ErrValidation))
+	}
+	// append time to the schema, the id is set here so that the audit entry has it
+	if data.ID.IsZero() {
+		data.ID = primitive.NewObjectID()
 	}
-	// append time to the schema
 	data.LastUpdate = time.Now().UnixNano()
+	data.DeletedAt = 0
+	doc := *data
+	m := mutation{id: data.ID.Hex(), changes: diff(nil, flatten(data)), doc: &doc}
+	if err = encryptCustom(&data.Custom); err != nil {
+		return log.done(err)
+	}
 	// collection
-	err := c.Insert(&data)
+	_, err = c.InsertOne(ctx, data)
 	if err != nil {
-		r.Error(DBINSERT+" %v\n", err)
-		return err
+		return log.done(dbError(err))
 	}
-	// all good
-	return nil
+	r.record(ctx, log, DBINSERT, resource, m)
+	return log.done(nil)
 }
 
 // Update
-func (r *Connections) DBUpdate(body []byte) (schema.SchemaInterface, error) {
+// The update is conditional on the document version (LastUpdate), version is the expected
+// version from If-Match (the lastupdate in the body is ignored, a json number can't hold it exactly), 0 is unconditional
+func (r *Connections) DBUpdate(ctx context.Context, resource string, body []byte, version int64) (schema.SchemaInterface, error) {
 	var data, existing schema.SchemaInterface
-	s := r.DB.Clone()
-	defer s.Close()
-	c := s.DB(os.Getenv("MONGODB_DATABASENAME")).C(DBSCHEMA)
-	e := json.Unmarshal(body, &data)
+	log := r.log(ctx, DBUPDATE, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return data, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	e := decode(resource, body, &data)
 	if e != nil {
-		r.Error(DBUPDATE+" %v\n", e)
-		return data, e
+		return data, log.done(dbError(e))
 	}
-	r.Debug(DBUPDATE+" %v\n", data)
-	f := bson.IsObjectIdHex(data.ID.Hex())
-	if f == false {
-		return data, errors.New("bson ObjectId not valid")
+	log.Debug("body : %v", data)
+	if data.ID.IsZero() {
+		return data, log.done(ErrInvalidID)
 	}
-	// first find the collection with the given ID
-	err := c.FindId(data.ID).One(&existing)
+	log = r.log(ctx, DBUPDATE, resource, data.ID.Hex())
+	// first find the collection with the given ID (soft deleted documents must be restored first)
+	err = c.FindOne(ctx, bson.M{"_id": data.ID, DELETEDAT: notDeleted}).Decode(&existing)
 	if err != nil {
-		return data, err
+		return data, log.done(dbError(err))
+	}
+	if err = decryptCustom(&existing.Custom); err != nil {
+		return data, log.done(err)
+	}
+	log.Debug("from database : %v", existing)
+	current := existing.LastUpdate
+	if version != 0 && version != current {
+		return data, log.done(fmt.Errorf("%w : version %d does not match %d", ErrConflict, version, current))
 	}
-	r.Debug(DBUPDATE+": from database : %v ", existing)
 	data.LastUpdate = time.Now().UnixNano()
-	// now merge the 2 structs
+	data.DeletedAt = 0
+	// now merge the 2 structs, the audit entry has the fields that differ between the existing and merged documents
+	before := flatten(&existing)
 	em := mergo.Merge(&existing, data, mergo.WithOverride)
 	if em != nil {
-		return data, em
+		return data, log.done(em)
 	}
-	// update the merged structs
-	query := bson.M{"_id": bson.ObjectIdHex(data.ID.Hex())}
-	r.Debug(DBUPDATE+" : merged data : %v ", existing)
-	e = c.Update(query, existing)
+	doc := existing
+	m := mutation{id: data.ID.Hex(), changes: diff(before, flatten(&existing)), doc: &doc}
+	// update the merged structs, only if nobody else updated the document since it was read
+	query := bson.M{"_id": data.ID}
+	if current != 0 {
+		query["lastupdate"] = current
+	}
+	log.Debug("merged data : %v", existing)
+	if err = encryptCustom(&existing.Custom); err != nil {
+		return data, log.done(err)
+	}
+	res, e := c.ReplaceOne(ctx, query, existing)
 	if e != nil {
-		r.Error(DBUPDATE+" %v\n", e)
-		return data, e
+		r.cacheDel(ctx, resource, data.ID.Hex())
+		return data, log.done(dbError(e))
+	}
+	if res.MatchedCount == 0 {
+		r.cacheDel(ctx, resource, data.ID.Hex())
+		return data, log.done(fmt.Errorf("%w : document changed since version %d", ErrConflict, current))
 	}
+	r.cacheSet(ctx, resource, existing)
+	r.record(ctx, log, DBUPDATE, resource, m)
 	// all good
-	return data, nil
+	return data, log.done(nil)
 }
 
 // DBGet gets the schema/data from the database
-func (r *Connections) DBGet(id string) (schema.SchemaInterface, error) {
+func (r *Connections) DBGet(ctx context.Context, resource string, id string) (schema.SchemaInterface, error) {
 
 	var data schema.SchemaInterface
-	s := r.DB.Clone()
-	defer s.Close()
-	c := s.DB(os.Getenv("MONGODB_DATABASENAME")).C(DBSCHEMA)
+	log := r.log(ctx, DBGET, resource, id)
+	name, err := collection(resource)
+	if err != nil {
+		return data, log.done(err)
+	}
 	// check the bson id
-	f := bson.IsObjectIdHex(id)
-	if f == false {
-		return data, errors.New("bson ObjectId not valid")
+	oid, err := objectID(id)
+	if err != nil {
+		return data, log.done(err)
+	}
+	// read through the cache
+	if cached, ok := r.cacheGet(ctx, resource, id); ok {
+		return cached, log.done(decryptCustom(&cached.Custom))
 	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	// first find the collection with the given ID
-	query := bson.M{"_id": bson.ObjectIdHex(id)}
-	e := c.Find(query).One(&data)
-	r.Trace("Get : data : %v ", data)
+	e := r.retry(ctx, log, func() error {
+		return dbError(c.FindOne(ctx, bson.M{"_id": oid, DELETEDAT: notDeleted}).Decode(&data))
+	})
+	log.Trace("data : %v", data)
 	if e != nil {
-		r.Error(DBGET+" %v\n", e)
-		return data, e
+		return data, log.done(e)
 	}
+	// the cache keeps the encrypted document
+	r.cacheSet(ctx, resource, data)
 	// all good
-	return data, nil
+	return data, log.done(decryptCustom(&data.Custom))
 }
 
-// DBDelete deletes schema/data from the database
-func (r *Connections) DBDelete(id string) error {
-	s := r.DB.Clone()
-	defer s.Close()
-	c := s.DB(os.Getenv("MONGODB_DATABASENAME")).C(DBSCHEMA)
+// DBDelete soft deletes schema/data, the document gets a deletedat marker and is hard deleted by the purge
+func (r *Connections) DBDelete(ctx context.Context, resource string, id string) error {
+	log := r.log(ctx, DBDELETE, resource, id)
+	name, err := collection(resource)
+	if err != nil {
+		return log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	// check the bson id
-	f := bson.IsObjectIdHex(id)
-	if f == false {
-		return errors.New("bson ObjectId not valid")
+	oid, err := objectID(id)
+	if err != nil {
+		return log.done(err)
 	}
-	// first find the collection with the given ID
-	query := bson.M{"_id": bson.ObjectIdHex(id)}
-	e := c.Remove(query)
+	now := time.Now().UnixNano()
+	res, e := c.UpdateOne(ctx, bson.M{"_id": oid, DELETEDAT: notDeleted}, bson.M{"$set": bson.M{DELETEDAT: now, "lastupdate": now}})
+	r.cacheDel(ctx, resource, id)
 	if e != nil {
-		r.Error(DBDELETE+" %v\n", e)
-		return e
+		return log.done(dbError(e))
+	}
+	if res.MatchedCount == 0 {
+		return log.done(dbError(mongo.ErrNoDocuments))
 	}
+	r.record(ctx, log, DBDELETE, resource, mutation{id: id, changes: []schema.Change{{Field: DELETEDAT, After: now}}})
 	// all good
-	return nil
+	return log.done(nil)
+}
+
+// DBRestore clears the deletedat marker of a soft deleted document
+func (r *Connections) DBRestore(ctx context.Context, resource string, id string) error {
+	log := r.log(ctx, DBRESTORE, resource, id)
+	name, err := collection(resource)
+	if err != nil {
+		return log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	oid, err := objectID(id)
+	if err != nil {
+		return log.done(err)
+	}
+	update := bson.M{"$unset": bson.M{DELETEDAT: ""}, "$set": bson.M{"lastupdate": time.Now().UnixNano()}}
+	res, e := c.UpdateOne(ctx, bson.M{"_id": oid, DELETEDAT: bson.M{"$exists": true}}, update)
+	if e != nil {
+		return log.done(dbError(e))
+	}
+	if res.MatchedCount == 0 {
+		return log.done(fmt.Errorf("%w : %s is not deleted", ErrNotFound, id))
+	}
+	r.record(ctx, log, DBRESTORE, resource, mutation{id: id})
+	// all good
+	return log.done(nil)
+}
+
+// DBPurge hard deletes the documents soft deleted before the given time, returns the number of documents deleted
+func (r *Connections) DBPurge(ctx context.Context, resource string, before time.Time) (int, error) {
+	log := r.log(ctx, DBPURGE, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return 0, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	res, e := c.DeleteMany(ctx, bson.M{DELETEDAT: bson.M{"$lt": before.UnixNano()}})
+	if e != nil {
+		return 0, log.done(dbError(e))
+	}
+	log.Info("%d documents deleted before %v", res.DeletedCount, before)
+	return int(res.DeletedCount), log.done(nil)
 }
 
 // DBbList lists a range of data from the database
-func (r *Connections) DBList(lr *schema.ListRange) ([]schema.SchemaInterface, error) {
+func (r *Connections) DBList(ctx context.Context, resource string, lr *schema.ListRange) ([]schema.SchemaInterface, error) {
 
 	var data schema.SchemaInterface
 	var payload []schema.SchemaInterface
 
-	s := r.DB.Clone()
-	defer s.Close()
-	c := s.DB(os.Getenv("MONGODB_DATABASENAME")).C(DBSCHEMA)
+	log := r.log(ctx, DBLIST, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return payload, log.done(err)
+	}
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	// first find the collection with the given ID
-	iter := c.Find(nil).Sort("_id").Skip(lr.From).Limit(lr.To).Iter()
+	// a 0 limit would return the whole collection
+	limit := lr.To - lr.From
+	if limit <= 0 || lr.From < 0 {
+		return payload, log.done(fmt.Errorf("%w : list range %d to %d not valid", ErrValidation, lr.From, lr.To))
+	}
+	query := listQuery(lr)
+	skip := lr.From
+	// keyset pagination, continue after the last _id of the previous page
+	if lr.Cursor != "" {
+		oid, err := objectID(lr.Cursor)
+		if err != nil {
+			return payload, log.done(err)
+		}
+		query["_id"] = bson.M{"$gt": oid}
+		skip = 0
+	}
+	log.Trace("query : %v", query)
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(int64(skip)).SetLimit(int64(limit))
+	err = r.retry(ctx, log, func() error {
+		payload = nil
+		cur, err := c.Find(ctx, query, opts)
+		if err != nil {
+			return dbError(err)
+		}
+		defer cur.Close(ctx)
+		for cur.Next(ctx) {
+			// reset so that the Data map isn't shared between documents
+			data = schema.SchemaInterface{}
+			if err = cur.Decode(&data); err != nil {
+				return dbError(err)
+			}
+			if err = decryptCustom(&data.Custom); err != nil {
+				return err
+			}
+			log.Trace("data : %v", data)
+			payload = append(payload, data)
+		}
+		return dbError(cur.Err())
+	})
+	// all good (or not)
+	return payload, log.done(err)
+}
 
-	for iter.Next(&data) {
-		r.Trace("Data : %v ", data)
-		payload = append(payload, data)
+// DBCount counts all the documents matching the list search (the range and cursor are ignored)
+func (r *Connections) DBCount(ctx context.Context, resource string, lr *schema.ListRange) (int, error) {
+	log := r.log(ctx, DBCOUNT, resource, "")
+	name, err := collection(resource)
+	if err != nil {
+		return 0, log.done(err)
 	}
-	if iter.Err() != nil {
-		r.Error(DBLIST+" %v\n", iter.Err())
-		iter.Close()
-		return payload, iter.Err()
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(name)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var n int64
+	err = r.retry(ctx, log, func() error {
+		var e error
+		n, e = c.CountDocuments(ctx, listQuery(lr))
+		return dbError(e)
+	})
+	if err != nil {
+		return 0, log.done(err)
 	}
-	iter.Close()
 	// all good
-	return payload, nil
+	return int(n), log.done(nil)
 }
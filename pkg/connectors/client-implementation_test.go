@@ -2,21 +2,27 @@ package connectors
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/auth"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/encryption"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
-	"github.com/globalsign/mgo/bson"
-	"github.com/microlib/simple"
-)
-
-var (
-	m map[string]string
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type errReader int
@@ -44,7 +50,7 @@ func NewHttpTestClient(fn RoundTripFunc) *http.Client {
 
 // DataLayer is an interface to access to the database struct.
 
-func NewClientTestConnections(file string, status int, logger *simple.Logger) Clients {
+func NewClientTestConnections(file string, status int, logger *logging.Logger) Clients {
 
 	// we first load the json payload to simulate a call to middleware
 	// for now just ignore failures.
@@ -65,45 +71,9 @@ func NewClientTestConnections(file string, status int, logger *simple.Logger) Cl
 
 	m = make(map[string]string)
 	redisClient := &FakeRedis{}
-	mgo := NewFakeSession()
-
-	return &Connections{Http: httpClient, Redis: redisClient, DB: mgo, Name: "FakeConnections", l: logger}
-}
-
-// implementation
-
-// fake redis Get
-func (r *Connections) Get(key string) (string, error) {
-	if key == "error" {
-		return "", errors.New("Get method failed")
-	}
-	return m[key], nil
-}
-
-// fake redis Set
-func (r *Connections) Set(key string, value string, expr time.Duration) (string, error) {
-	if key == "error" {
-		return "", errors.New("Set method failed")
-	}
-	m[key] = value
-	return value, nil
-}
-
-// fake redis Close
-func (r *Connections) Del(key string) error {
-	if key == "error" {
-		return errors.New("Del method failed")
-	}
-	delete(m, key)
-	return nil
-}
-
-func (r *FakeRedis) Close() error {
-	return nil
-}
+	client := NewFakeClient()
 
-func (r *Connections) Close() error {
-	return nil
+	return &Connections{Http: httpClient, Redis: redisClient, DB: client, Name: "FakeConnections", l: logger, cfg: config.Default()}
 }
 
 func assertEqual(t *testing.T, a interface{}, b interface{}) {
@@ -114,14 +84,14 @@ func assertEqual(t *testing.T, a interface{}, b interface{}) {
 
 func TestImplementation(t *testing.T) {
 
-	logger := &simple.Logger{Level: "info"}
+	logger := logging.New(os.Stdout, "info")
 
 	t.Run("Insert : should pass", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
 		custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
 		d := schema.SchemaInterface{LastUpdate: time.Now().UnixNano(), MetaInfo: "nada", Custom: custom}
 		b, _ := json.Marshal(d)
-		err := conn.DBInsert(b)
+		err := conn.DBInsert(context.Background(), DBSCHEMA, b)
 		if err != nil {
 			t.Errorf(fmt.Sprintf("Test Insert %s returned with error - got (%s) wanted (%s)", "DBInsert", "nil", "error"))
 		}
@@ -130,7 +100,7 @@ func TestImplementation(t *testing.T) {
 	t.Run("Insert : should fail", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 500, logger)
 		b, _ := json.Marshal([]byte("{ "))
-		err := conn.DBInsert(b)
+		err := conn.DBInsert(context.Background(), DBSCHEMA, b)
 		if err == nil {
 			t.Errorf(fmt.Sprintf("Test Insert %s returned with no error - got (%s) wanted (%s)", "DBInsert", "nil", "error"))
 		}
@@ -141,7 +111,7 @@ func TestImplementation(t *testing.T) {
 		custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
 		d := schema.SchemaInterface{LastUpdate: time.Now().UnixNano(), MetaInfo: "ERROR", Custom: custom}
 		b, _ := json.Marshal(d)
-		err := conn.DBInsert(b)
+		err := conn.DBInsert(context.Background(), DBSCHEMA, b)
 		if err == nil {
 			t.Errorf(fmt.Sprintf("Test Insert %s returned with no error - got (%s) wanted (%s)", "DBInsert", "nil", "error"))
 		}
@@ -150,9 +120,9 @@ func TestImplementation(t *testing.T) {
 	t.Run("DBUpdate : should pass", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
 		custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
-		d := schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), MetaInfo: "nada", Custom: custom}
+		d := schema.SchemaInterface{ID: fakeDocument().ID, MetaInfo: "nada", Custom: custom}
 		b, _ := json.Marshal(d)
-		s, err := conn.DBUpdate(b)
+		s, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 0)
 		if err != nil {
 			t.Errorf(fmt.Sprintf("Test Update %s returned with error - got (%s) wanted (%s)", "DBUpdate", "error", "nil"))
 		}
@@ -162,7 +132,7 @@ func TestImplementation(t *testing.T) {
 	t.Run("DBUpdate : should fail", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 500, logger)
 		b, _ := json.Marshal([]byte("{ "))
-		_, err := conn.DBUpdate(b)
+		_, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 0)
 		if err == nil {
 			t.Errorf(fmt.Sprintf("Test Update %s returned with no error - got (%s) wanted (%s)", "DBUpdate", "nil", "error"))
 		}
@@ -171,9 +141,9 @@ func TestImplementation(t *testing.T) {
 	t.Run("DBUpdate : should fail (forced error)", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
 		custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
-		d := schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), MetaInfo: "ERROR", Custom: custom}
+		d := schema.SchemaInterface{ID: fakeDocument().ID, MetaInfo: "ERROR", Custom: custom}
 		b, _ := json.Marshal(d)
-		s, err := conn.DBUpdate(b)
+		s, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 0)
 		if err == nil {
 			t.Errorf(fmt.Sprintf("Test Update %s returned with no error - got (%s) wanted (%s)", "DBUpdate", "nil", "error"))
 		}
@@ -182,7 +152,7 @@ func TestImplementation(t *testing.T) {
 
 	t.Run("DBGet : should pass", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
-		_, err := conn.DBGet("5cc042307ccc69ada893144c")
+		_, err := conn.DBGet(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
 		if err != nil {
 			t.Errorf(fmt.Sprintf("Test Get %s returned with error - got (%s) wanted (%s)", "DBGet", "error", "nil"))
 		}
@@ -190,7 +160,7 @@ func TestImplementation(t *testing.T) {
 
 	t.Run("DBDelete : should pass", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
-		err := conn.DBDelete("5cc042307ccc69ada893144c")
+		err := conn.DBDelete(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
 		if err != nil {
 			t.Errorf(fmt.Sprintf("Test Delete %s returned with error - got (%s) wanted (%s)", "DBDelete", "error", "nil"))
 		}
@@ -199,11 +169,917 @@ func TestImplementation(t *testing.T) {
 	t.Run("DBList : should pass", func(t *testing.T) {
 		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
 		lr := &schema.ListRange{From: 10, To: 20, Search: "NA"}
-		s, err := conn.DBList(lr)
+		s, err := conn.DBList(context.Background(), DBSCHEMA, lr)
 		conn.Info("DBList %v\n", s)
 		if err != nil {
 			t.Errorf(fmt.Sprintf("Test Update %s returned with error - got (%s) wanted (%s)", "DBUpdate", "nil", "error"))
 		}
 	})
 
+	t.Run("DBList : cursor should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		lr := &schema.ListRange{From: 0, To: 20, Cursor: "5cc042307ccc69ada893144c"}
+		_, err := conn.DBList(context.Background(), DBSCHEMA, lr)
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test List %s returned with error - got (%s) wanted (%s)", "DBList", "error", "nil"))
+		}
+	})
+
+	t.Run("DBList : invalid cursor should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		lr := &schema.ListRange{From: 0, To: 20, Cursor: "nada"}
+		_, err := conn.DBList(context.Background(), DBSCHEMA, lr)
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test List %s returned with no error - got (%s) wanted (%s)", "DBList", "nil", "error"))
+		}
+	})
+
+	t.Run("DBList : invalid range should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		lr := &schema.ListRange{From: 20, To: 10}
+		_, err := conn.DBList(context.Background(), DBSCHEMA, lr)
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test List %s returned with no error - got (%s) wanted (%s)", "DBList", "nil", "error"))
+		}
+	})
+
+	t.Run("DBList : empty range should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		lr := &schema.ListRange{From: 5, To: 5}
+		_, err := conn.DBList(context.Background(), DBSCHEMA, lr)
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf(fmt.Sprintf("Test List %s returned - got (%v) wanted (%v)", "DBList", err, ErrValidation))
+		}
+	})
+
+	t.Run("DBCount : should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		n, err := conn.DBCount(context.Background(), DBSCHEMA, &schema.ListRange{From: 0, To: 20, Search: "test"})
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test Count %s returned with error - got (%s) wanted (%s)", "DBCount", "error", "nil"))
+		}
+		assertEqual(t, n, 10)
+	})
+
+	t.Run("DBGet : not found should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		_, err := conn.DBGet(context.Background(), DBSCHEMA, FAKENOTFOUND)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test Get %s returned - got (%v) wanted (%v)", "DBGet", err, ErrNotFound))
+		}
+	})
+
+	t.Run("DBDelete : invalid id should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		err := conn.DBDelete(context.Background(), DBSCHEMA, "nada")
+		if !errors.Is(err, ErrInvalidID) {
+			t.Errorf(fmt.Sprintf("Test Delete %s returned - got (%v) wanted (%v)", "DBDelete", err, ErrInvalidID))
+		}
+		err = conn.DBDelete(context.Background(), DBSCHEMA, FAKENOTFOUND)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test Delete %s returned - got (%v) wanted (%v)", "DBDelete", err, ErrNotFound))
+		}
+	})
+
+	t.Run("DBUpdate : not found should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		b := []byte(`{"_id":"` + FAKENOTFOUND + `","metainfo":"nada"}`)
+		_, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 0)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test Update %s returned - got (%v) wanted (%v)", "DBUpdate", err, ErrNotFound))
+		}
+	})
+
+	t.Run("DBInsert : invalid json should fail validation", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		for _, body := range []string{"{ ", "null", `{"lastupdate":"nada"}`} {
+			err := conn.DBInsert(context.Background(), DBSCHEMA, []byte(body))
+			if !errors.Is(err, ErrValidation) {
+				t.Errorf(fmt.Sprintf("Test Insert %s %s returned - got (%v) wanted (%v)", "DBInsert", body, err, ErrValidation))
+			}
+		}
+	})
+
+	t.Run("dbError : should map the driver errors", func(t *testing.T) {
+		tests := []struct {
+			err  error
+			kind error
+		}{
+			{mongo.ErrNoDocuments, ErrNotFound},
+			{mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "E11000 duplicate key error"}}}, ErrConflict},
+			{io.EOF, ErrUnavailable},
+			{mongo.ErrClientDisconnected, ErrUnavailable},
+			{context.DeadlineExceeded, ErrTimeout},
+			{context.Canceled, ErrTimeout},
+			{errors.New("server selection error: context deadline exceeded"), ErrUnavailable},
+		}
+		for _, tt := range tests {
+			if err := dbError(tt.err); !errors.Is(err, tt.kind) {
+				t.Errorf(fmt.Sprintf("Test dbError returned - got (%v) wanted (%v)", err, tt.kind))
+			}
+		}
+		other := errors.New("other")
+		assertEqual(t, dbError(other), other)
+		assertEqual(t, dbError(nil), nil)
+	})
+
+	t.Run("DBGet : should read through the cache", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		key := cacheKey(DBSCHEMA, "5cc042307ccc69ada893144c")
+		_, err := conn.DBGet(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Get %s returned with error - got (%v) wanted (%s)", "DBGet", err, "nil"))
+		}
+		if m[key] == "" {
+			t.Fatalf(fmt.Sprintf("Test Get %s not cached - key (%s)", "DBGet", key))
+		}
+		// a cache hit returns the cached document
+		m[key] = `{"_id":"5cc042307ccc69ada893144c","metainfo":"cached"}`
+		d, err := conn.DBGet(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Get %s returned with error - got (%v) wanted (%s)", "DBGet", err, "nil"))
+		}
+		assertEqual(t, d.MetaInfo, "cached")
+		// a corrupt entry is treated as a miss
+		m[key] = "{ "
+		d, _ = conn.DBGet(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
+		assertEqual(t, d.MetaInfo, "Fake data")
+	})
+
+	t.Run("DBGet : a 0 cache ttl should disable the cache", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		conn.Config().Cache.TTL = 0
+		conn.DBGet(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
+		assertEqual(t, m[cacheKey(DBSCHEMA, "5cc042307ccc69ada893144c")], "")
+	})
+
+	t.Run("DBUpdate : matching version should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		b := []byte(`{"_id":"5cc042307ccc69ada893144c","metainfo":"nada"}`)
+		d, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 123434)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Update %s returned with error - got (%v) wanted (%s)", "DBUpdate", err, "nil"))
+		}
+		if d.LastUpdate == 123434 {
+			t.Errorf(fmt.Sprintf("Test Update %s version not updated - got (%d)", "DBUpdate", d.LastUpdate))
+		}
+		// the lastupdate of the body is not a version
+		b = []byte(`{"_id":"5cc042307ccc69ada893144c","lastupdate":1,"metainfo":"nada"}`)
+		_, err = conn.DBUpdate(context.Background(), DBSCHEMA, b, 0)
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test Update %s returned with error - got (%v) wanted (%s)", "DBUpdate", err, "nil"))
+		}
+	})
+
+	t.Run("DBUpdate : stale version should conflict", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		b := []byte(`{"_id":"5cc042307ccc69ada893144c","metainfo":"nada"}`)
+		_, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 1)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf(fmt.Sprintf("Test Update %s returned - got (%v) wanted (%v)", "DBUpdate", err, ErrConflict))
+		}
+	})
+
+	t.Run("DBUpdate : concurrent change should conflict", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		b := []byte(`{"_id":"5cc042307ccc69ada893144c","metainfo":"CHANGED"}`)
+		_, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 123434)
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf(fmt.Sprintf("Test Update %s returned - got (%v) wanted (%v)", "DBUpdate", err, ErrConflict))
+		}
+	})
+
+	t.Run("DBUpdate : should refresh the cache", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		key := cacheKey(DBSCHEMA, "5cc042307ccc69ada893144c")
+		b := []byte(`{"_id":"5cc042307ccc69ada893144c","metainfo":"updated"}`)
+		_, err := conn.DBUpdate(context.Background(), DBSCHEMA, b, 0)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Update %s returned with error - got (%v) wanted (%s)", "DBUpdate", err, "nil"))
+		}
+		var cached schema.SchemaInterface
+		json.Unmarshal([]byte(m[key]), &cached)
+		assertEqual(t, cached.MetaInfo, "updated")
+		assertEqual(t, cached.Custom.Name, "test")
+	})
+
+	t.Run("DBDelete : should invalidate the cache", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		key := cacheKey(DBSCHEMA, "5cc042307ccc69ada893144c")
+		m[key] = "{}"
+		conn.DBDelete(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
+		if _, ok := m[key]; ok {
+			t.Errorf(fmt.Sprintf("Test Delete %s cache entry not removed - key (%s)", "DBDelete", key))
+		}
+	})
+
+	t.Run("DBGet : expired context should time out", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := conn.DBGet(ctx, DBSCHEMA, "5cc042307ccc69ada893144d")
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf(fmt.Sprintf("Test Get %s returned - got (%v) wanted (%v)", "DBGet", err, ErrTimeout))
+		}
+		ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+		time.Sleep(time.Millisecond)
+		_, err = conn.DBList(ctx, DBSCHEMA, &schema.ListRange{From: 0, To: 20})
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf(fmt.Sprintf("Test List %s returned - got (%v) wanted (%v)", "DBList", err, ErrTimeout))
+		}
+	})
+
+	t.Run("withTimeout : should use the operation timeout", func(t *testing.T) {
+		conn := &Connections{l: logger, cfg: config.Default()}
+		conn.cfg.Operation.Timeout = 2
+		ctx, cancel := conn.withTimeout(context.Background())
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		if left := time.Until(deadline); left > 2*time.Second || left < time.Second {
+			t.Errorf(fmt.Sprintf("Test withTimeout deadline - got (%v) wanted (%v)", left, 2*time.Second))
+		}
+	})
+
+	t.Run("log : should tag the lines with the request id and operation", func(t *testing.T) {
+		var buf bytes.Buffer
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logging.New(&buf, "debug"))
+		ctx := logging.WithRequestID(context.Background(), "abc-123")
+		conn.DBDelete(ctx, DBSCHEMA, "nada")
+		var line map[string]interface{}
+		if err := json.Unmarshal(bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))[0], &line); err != nil {
+			t.Fatalf(fmt.Sprintf("Test log %s returned with error - got (%v) wanted (%s)", "DBDelete", err, "nil"))
+		}
+		assertEqual(t, line["level"], "ERROR")
+		assertEqual(t, line["requestid"], "abc-123")
+		assertEqual(t, line["operation"], "DBDelete")
+		assertEqual(t, line["resource"], DBSCHEMA)
+		assertEqual(t, line["id"], "nada")
+		if _, ok := line["durationms"]; !ok {
+			t.Errorf(fmt.Sprintf("Test log %s has no durationms %v", "DBDelete", line))
+		}
+	})
+
+	t.Run("encryptCustom : should encrypt the pii fields", func(t *testing.T) {
+		ci, _ := encryption.New("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, encryption.KEYSIZE)}, nil)
+		SetEncryption(ci)
+		defer SetEncryption(nil)
+		custom := schema.CustomDetail{Name: "Jane", Surname: "Doe", Email: "jane@test.com", Title: "Dr", Mobile: "+353 1234567"}
+		c := custom
+		if err := encryptCustom(&c); err != nil {
+			t.Fatalf(fmt.Sprintf("Test encryptCustom returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		assertEqual(t, c.Title, "Dr")
+		assertEqual(t, c.Address, "")
+		for _, val := range []string{c.Name, c.Surname, c.Email, c.Mobile} {
+			assertEqual(t, strings.HasPrefix(val, encryption.PREFIX), true)
+		}
+		q, _ := emailQuery("jane@test.com").(bson.M)
+		assertEqual(t, q["$in"].([]string)[0], c.Email)
+		if err := decryptCustom(&c); err != nil {
+			t.Fatalf(fmt.Sprintf("Test decryptCustom returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		assertEqual(t, c, custom)
+	})
+
+	t.Run("decryptCustom : plaintext values that look encrypted should be kept", func(t *testing.T) {
+		ci, _ := encryption.New("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, encryption.KEYSIZE)}, nil)
+		SetEncryption(ci)
+		defer SetEncryption(nil)
+		fakeStored, fakeList = nil, true
+		defer func() { fakeStored, fakeList = nil, false }()
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		// the title isn't encrypted and the name was stored before the encryption was enabled
+		if err := conn.DBInsert(context.Background(), DBSCHEMA, []byte(`{"custom":{"name":"Jane","title":"enc:x"}}`)); err != nil {
+			t.Fatalf(fmt.Sprintf("Test Insert %s returned with error - got (%v) wanted (%s)", "DBInsert", err, "nil"))
+		}
+		legacy := schema.SchemaInterface{ID: primitive.NewObjectID(), Custom: schema.CustomDetail{Name: "enc:k1:nada", Surname: "enc:k9:" + strings.Repeat("A", 40)}}
+		fakeStored = append(fakeStored, legacy)
+		list, err := conn.DBList(context.Background(), DBSCHEMA, &schema.ListRange{From: 0, To: 20})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test List %s returned with error - got (%v) wanted (%s)", "DBList", err, "nil"))
+		}
+		assertEqual(t, len(list), 2)
+		assertEqual(t, list[0].Custom.Title, "enc:x")
+		assertEqual(t, list[0].Custom.Name, "Jane")
+		assertEqual(t, list[1].Custom.Name, "enc:k1:nada")
+		assertEqual(t, list[1].Custom.Surname, legacy.Custom.Surname)
+		changes := []schema.Change{{Field: "custom.title", After: "enc:x"}, {Field: "custom.name", After: "enc:k1:nada"}}
+		assertEqual(t, openChanges(changes), nil)
+		assertEqual(t, changes[0].After, "enc:x")
+	})
+
+	t.Run("diff : should list the changed fields", func(t *testing.T) {
+		before := flatten(&schema.SchemaInterface{LastUpdate: 1, Custom: schema.CustomDetail{Name: "John", Email: "john@test.com"}})
+		after := flatten(&schema.SchemaInterface{LastUpdate: 2, MetaInfo: "vip", Custom: schema.CustomDetail{Name: "Jane", Email: "john@test.com"}})
+		changes := diff(before, after)
+		if len(changes) != 2 {
+			t.Fatalf(fmt.Sprintf("Test diff - got (%v) wanted (%d) changes", changes, 2))
+		}
+		assertEqual(t, changes[0], schema.Change{Field: "custom.name", Before: "John", After: "Jane"})
+		assertEqual(t, changes[1], schema.Change{Field: "metainfo", After: "vip"})
+		assertEqual(t, len(diff(before, before)), 0)
+	})
+
+	t.Run("DBUpdate : should write an audit entry", func(t *testing.T) {
+		fakeAudit = nil
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		ctx := auth.WithClaims(logging.WithRequestID(context.Background(), "req-1"), auth.Claims{"sub": "jane"})
+		d := schema.SchemaInterface{ID: fakeDocument().ID, Custom: schema.CustomDetail{Name: "John"}}
+		b, _ := json.Marshal(d)
+		if _, err := conn.DBUpdate(ctx, DBSCHEMA, b, 0); err != nil {
+			t.Fatalf(fmt.Sprintf("Test Update %s returned with error - got (%v) wanted (%s)", "DBUpdate", err, "nil"))
+		}
+		if len(fakeAudit) != 1 {
+			t.Fatalf(fmt.Sprintf("Test Update %s audit entries - got (%d) wanted (%d)", "DBUpdate", len(fakeAudit), 1))
+		}
+		entry := fakeAudit[0]
+		assertEqual(t, entry.Operation, "DBUpdate")
+		assertEqual(t, entry.Actor, "jane")
+		assertEqual(t, entry.RequestID, "req-1")
+		assertEqual(t, entry.DocumentID, d.ID.Hex())
+		assertEqual(t, fmt.Sprint(entry.Changes), fmt.Sprint([]schema.Change{{Field: "custom.name", Before: "test", After: "John"}}))
+	})
+
+	t.Run("DBHistory : should list the audit entries newest first", func(t *testing.T) {
+		fakeAudit = nil
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		id := fakeDocument().ID.Hex()
+		conn.DBInsert(context.Background(), DBSCHEMA, []byte(`{"_id":"`+id+`","custom":{"name":"test"}}`))
+		conn.DBDelete(context.Background(), DBSCHEMA, id)
+		history, err := conn.DBHistory(context.Background(), DBSCHEMA, id, &schema.ListRange{From: 0, To: 20})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test History %s returned with error - got (%v) wanted (%s)", "DBHistory", err, "nil"))
+		}
+		if len(history) != 2 {
+			t.Fatalf(fmt.Sprintf("Test History %s - got (%d) wanted (%d) entries", "DBHistory", len(history), 2))
+		}
+		assertEqual(t, history[0].Operation, "DBDelete")
+		assertEqual(t, history[0].Actor, ANONYMOUS)
+		assertEqual(t, history[1].Operation, "DBInsert")
+		assertEqual(t, history[1].Changes[0].Field, "custom.name")
+	})
+
+	t.Run("DBHistory : should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		_, err := conn.DBHistory(context.Background(), DBSCHEMA, "nada", &schema.ListRange{})
+		if !errors.Is(err, ErrInvalidID) {
+			t.Errorf(fmt.Sprintf("Test History %s returned - got (%v) wanted (%v)", "DBHistory", err, ErrInvalidID))
+		}
+		_, err = conn.DBHistory(context.Background(), "nada", fakeDocument().ID.Hex(), &schema.ListRange{})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test History %s returned - got (%v) wanted (%v)", "DBHistory", err, ErrNotFound))
+		}
+	})
+
+	t.Run("audit : should encrypt the pii values", func(t *testing.T) {
+		ci, _ := encryption.New("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, encryption.KEYSIZE)}, nil)
+		SetEncryption(ci)
+		defer SetEncryption(nil)
+		fakeAudit = nil
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		id := fakeDocument().ID.Hex()
+		conn.DBInsert(context.Background(), DBSCHEMA, []byte(`{"_id":"`+id+`","custom":{"email":"jane@test.com"}}`))
+		stored := fakeAudit[0].Changes[0].After.(string)
+		assertEqual(t, strings.HasPrefix(stored, encryption.PREFIX), true)
+		history, _ := conn.DBHistory(context.Background(), DBSCHEMA, id, &schema.ListRange{})
+		assertEqual(t, history[0].Changes[0].After, "jane@test.com")
+	})
+
+	t.Run("DBGet : should decrypt the cached document", func(t *testing.T) {
+		ci, _ := encryption.New("k1", map[string][]byte{"k1": bytes.Repeat([]byte{1}, encryption.KEYSIZE)}, nil)
+		SetEncryption(ci)
+		defer SetEncryption(nil)
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		doc := fakeDocument()
+		encryptCustom(&doc.Custom)
+		conn.(*Connections).cacheSet(context.Background(), DBSCHEMA, doc)
+		assertEqual(t, strings.Contains(m[cacheKey(DBSCHEMA, doc.ID.Hex())], "test@test.com"), false)
+		data, err := conn.DBGet(context.Background(), DBSCHEMA, doc.ID.Hex())
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Get %s returned with error - got (%v) wanted (%s)", "DBGet", err, "nil"))
+		}
+		assertEqual(t, data.Custom, fakeDocument().Custom)
+	})
+
+	t.Run("listQuery : email should be an exact match", func(t *testing.T) {
+		q := listQuery(&schema.ListRange{Email: "jane@test.com"})
+		assertEqual(t, q["custom.email"], "jane@test.com")
+	})
+
+	t.Run("retry : 0 retries should not retry", func(t *testing.T) {
+		conn := &Connections{l: logger, cfg: config.Default()}
+		conn.cfg.Operation.Retries = 0
+		calls := 0
+		err := conn.retry(context.Background(), conn.log(context.Background(), DBGET, DBSCHEMA, ""), func() error {
+			calls++
+			return ErrUnavailable
+		})
+		assertEqual(t, err, ErrUnavailable)
+		assertEqual(t, calls, 1)
+	})
+
+	t.Run("backoff : should double up to the max", func(t *testing.T) {
+		assertEqual(t, backoff(0, time.Second, 30*time.Second), time.Second)
+		assertEqual(t, backoff(3, time.Second, 30*time.Second), 8*time.Second)
+		assertEqual(t, backoff(5, time.Second, 30*time.Second), 30*time.Second)
+		assertEqual(t, backoff(100, time.Second, 30*time.Second), 30*time.Second)
+	})
+
+	t.Run("retry : should pass once the database is back", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		calls := 0
+		err := conn.(*Connections).retry(context.Background(), conn.(*Connections).log(context.Background(), DBGET, DBSCHEMA, ""), func() error {
+			calls++
+			if calls < 2 {
+				return dbError(mongo.ErrClientDisconnected)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test retry %s returned with error - got (%v) wanted (%s)", "retry", err, "nil"))
+		}
+		assertEqual(t, calls, 2)
+	})
+
+	t.Run("retry : should not retry other errors", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		calls := 0
+		err := conn.(*Connections).retry(context.Background(), conn.(*Connections).log(context.Background(), DBGET, DBSCHEMA, ""), func() error {
+			calls++
+			return dbError(mongo.ErrNoDocuments)
+		})
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test retry %s returned - got (%v) wanted (%v)", "retry", err, ErrNotFound))
+		}
+		assertEqual(t, calls, 1)
+	})
+
+	t.Run("retry : should give up when the database stays down", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		calls := 0
+		err := conn.(*Connections).retry(context.Background(), conn.(*Connections).log(context.Background(), DBGET, DBSCHEMA, ""), func() error {
+			calls++
+			return dbError(mongo.ErrClientDisconnected)
+		})
+		if !errors.Is(err, ErrUnavailable) {
+			t.Errorf(fmt.Sprintf("Test retry %s returned - got (%v) wanted (%v)", "retry", err, ErrUnavailable))
+		}
+		assertEqual(t, calls, config.DEFAULTRETRIES+1)
+	})
+
+	t.Run("Health : should report each dependency", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		deps := conn.Health(context.Background())
+		assertEqual(t, len(deps), 2)
+		assertEqual(t, deps[0].Name, "mongodb")
+		assertEqual(t, deps[0].Status, UP)
+		assertEqual(t, deps[1].Name, "redis")
+		assertEqual(t, deps[1].Status, UP)
+		down := &Connections{Redis: &FakeRedis{Down: true}, DB: FakeClient{Down: true}, l: logger, cfg: config.Default()}
+		deps = down.Health(context.Background())
+		assertEqual(t, deps[0].Status, DOWN)
+		assertEqual(t, deps[1].Status, DOWN)
+		if deps[0].Message == "" {
+			t.Errorf(fmt.Sprintf("Test Health %s returned no message - got (%v)", "mongodb", deps[0]))
+		}
+	})
+
+	t.Run("DBBulkInsert : should report each item", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		items := []json.RawMessage{
+			json.RawMessage(`{"metainfo":"test","custom":{"name":"test"}}`),
+			json.RawMessage(`{ "metainfo": 1 }`),
+			json.RawMessage(`null`),
+			json.RawMessage(`{"_id":"5cc042307ccc69ada893144c","metainfo":"test"}`),
+		}
+		res, err := conn.DBBulkInsert(context.Background(), DBSCHEMA, items)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test BulkInsert %s returned with error - got (%v) wanted (%s)", "DBBulkInsert", err, "nil"))
+		}
+		assertEqual(t, len(res), 4)
+		if res[0].Err != nil || !primitive.IsValidObjectID(res[0].ID) {
+			t.Errorf(fmt.Sprintf("Test BulkInsert %s item 0 - got (%v)", "DBBulkInsert", res[0]))
+		}
+		assertEqual(t, errors.Is(res[1].Err, ErrValidation), true)
+		assertEqual(t, errors.Is(res[2].Err, ErrValidation), true)
+		assertEqual(t, res[3].ID, "5cc042307ccc69ada893144c")
+	})
+
+	t.Run("DBInsert : unknown fields of a resource without a schema should fail", func(t *testing.T) {
+		RegisterResource(schema.Resource{Name: "affiliates"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		err := conn.DBInsert(context.Background(), "affiliates", []byte(`{"name":"x"}`))
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf(fmt.Sprintf("Test Insert %s returned - got (%v) wanted (%v)", "DBInsert", err, ErrValidation))
+		}
+		if err = conn.DBInsert(context.Background(), "affiliates", []byte(`{"data":{"name":"x"}}`)); err != nil {
+			t.Errorf(fmt.Sprintf("Test Insert %s returned with error - got (%v) wanted (%s)", "DBInsert", err, "nil"))
+		}
+		res, _ := conn.DBBulkInsert(context.Background(), "affiliates", []json.RawMessage{json.RawMessage(`{"name":"x"}`), json.RawMessage(`{"data":{"name":"x"}}`)})
+		assertEqual(t, errors.Is(res[0].Err, ErrValidation), true)
+		assertEqual(t, res[1].Err, nil)
+		// the resources with a schema are validated by the handlers
+		if err = conn.DBInsert(context.Background(), DBSCHEMA, []byte(`{"name":"x","custom":{"name":"x"}}`)); err != nil {
+			t.Errorf(fmt.Sprintf("Test Insert %s returned with error - got (%v) wanted (%s)", "DBInsert", err, "nil"))
+		}
+	})
+
+	t.Run("DBBulkInsert : should fail (forced error)", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		items := []json.RawMessage{json.RawMessage(`{"metainfo":"ERROR"}`)}
+		_, err := conn.DBBulkInsert(context.Background(), DBSCHEMA, items)
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test BulkInsert %s returned with no error - got (%s) wanted (%s)", "DBBulkInsert", "nil", "error"))
+		}
+		_, err = conn.DBBulkInsert(context.Background(), "nada", items)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test BulkInsert %s returned - got (%v) wanted (%v)", "DBBulkInsert", err, ErrNotFound))
+		}
+	})
+
+	t.Run("DBBulkUpsert : should report each item", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		key := cacheKey(DBSCHEMA, "5cc042307ccc69ada893144c")
+		m[key] = "{}"
+		items := []json.RawMessage{
+			json.RawMessage(`{"_id":"5cc042307ccc69ada893144c","metainfo":"test","custom":{"name":"John","surname":"Doe","email":"john@test.com"}}`),
+			json.RawMessage(`{"metainfo":"test","custom":{"name":"Jane","surname":"Doe","email":"jane@test.com"}}`),
+			json.RawMessage(`{"_id":"nada"}`),
+		}
+		res, err := conn.DBBulkUpsert(context.Background(), DBSCHEMA, items)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test BulkUpsert %s returned with error - got (%v) wanted (%s)", "DBBulkUpsert", err, "nil"))
+		}
+		assertEqual(t, res[0].Err, nil)
+		assertEqual(t, res[1].Err, nil)
+		assertEqual(t, errors.Is(res[2].Err, ErrValidation), true)
+		if _, ok := m[key]; ok {
+			t.Errorf(fmt.Sprintf("Test BulkUpsert %s cache entry not removed - key (%s)", "DBBulkUpsert", key))
+		}
+	})
+
+	t.Run("DBBulkDelete : should report each item", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		res, err := conn.DBBulkDelete(context.Background(), DBSCHEMA, []string{"5cc042307ccc69ada893144c", "nada"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test BulkDelete %s returned with error - got (%v) wanted (%s)", "DBBulkDelete", err, "nil"))
+		}
+		assertEqual(t, res[0].Err, nil)
+		assertEqual(t, res[1].Err, ErrInvalidID)
+		res, _ = conn.DBBulkDelete(context.Background(), DBSCHEMA, []string{"nada"})
+		assertEqual(t, res[0].Err, ErrInvalidID)
+	})
+
+	t.Run("DBBulkDelete : missing ids should be not found", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		fakeAudit = nil
+		res, err := conn.DBBulkDelete(context.Background(), DBSCHEMA, []string{FAKENOTFOUND, "5cc042307ccc69ada893144c", "nada"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test BulkDelete %s returned with error - got (%v) wanted (%s)", "DBBulkDelete", err, "nil"))
+		}
+		assertEqual(t, errors.Is(res[0].Err, ErrNotFound), true)
+		assertEqual(t, res[0].ID, FAKENOTFOUND)
+		assertEqual(t, res[1].Err, nil)
+		assertEqual(t, res[2].Err, ErrInvalidID)
+		// only the deleted document is audited
+		assertEqual(t, len(fakeAudit), 1)
+		assertEqual(t, fakeAudit[0].DocumentID, "5cc042307ccc69ada893144c")
+		fakeAudit = nil
+	})
+
+	t.Run("DBBulkDelete : repeated ids should conflict", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		fakeAudit = nil
+		res, err := conn.DBBulkDelete(context.Background(), DBSCHEMA, []string{"5cc042307ccc69ada893144c", "5cc042307ccc69ada893144c"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test BulkDelete %s returned with error - got (%v) wanted (%s)", "DBBulkDelete", err, "nil"))
+		}
+		assertEqual(t, res[0].Err, nil)
+		assertEqual(t, errors.Is(res[1].Err, ErrConflict), true)
+		assertEqual(t, res[1].ID, "5cc042307ccc69ada893144c")
+		assertEqual(t, len(fakeAudit), 1)
+		fakeAudit = nil
+	})
+
+	t.Run("bulkItems : a write error should fail the request", func(t *testing.T) {
+		bi := newBulkItems(1)
+		bi.queue(0, "5cc042307ccc69ada893144c", mongo.NewDeleteOneModel(), mutation{})
+		_, err := bi.done(errors.New("server selection error"))
+		assertEqual(t, errors.Is(err, ErrUnavailable), true)
+	})
+
+	t.Run("bulkItems : a bulk write exception should fail its items", func(t *testing.T) {
+		bi := newBulkItems(3)
+		bi.fail(0, ErrInvalidID)
+		bi.queue(1, "5cc042307ccc69ada893144c", mongo.NewInsertOneModel(), mutation{})
+		bi.queue(2, "5cc042307ccc69ada893144d", mongo.NewInsertOneModel(), mutation{})
+		berr := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 11000, Message: "E11000 duplicate key error"}}}}
+		res, err := bi.done(berr)
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test bulkItems returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		assertEqual(t, res[0].Err, ErrInvalidID)
+		assertEqual(t, res[1].Err, nil)
+		assertEqual(t, errors.Is(res[2].Err, ErrConflict), true)
+		berr = mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64, Message: "waiting for replication timed out"}}
+		res, _ = bi.done(berr)
+		assertEqual(t, errors.Is(res[1].Err, ErrUnavailable), true)
+	})
+
+	t.Run("DBIndex : should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		err := conn.(*Connections).DBIndex()
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test Index %s returned with error - got (%s) wanted (%s)", "DBIndex", "error", "nil"))
+		}
+	})
+
+	t.Run("listQuery : no search term should match all documents that are not deleted", func(t *testing.T) {
+		for _, search := range []string{"", "   "} {
+			query := listQuery(&schema.ListRange{From: 0, To: 20, Search: search})
+			if len(query) != 1 {
+				t.Errorf(fmt.Sprintf("Test listQuery %q returned a filter - got (%v) wanted (%v)", search, query, bson.M{DELETEDAT: notDeleted}))
+			}
+			assertEqual(t, fmt.Sprint(query[DELETEDAT]), fmt.Sprint(notDeleted))
+		}
+		query := listQuery(&schema.ListRange{From: 0, To: 20, IncludeDeleted: true})
+		if len(query) != 0 {
+			t.Errorf(fmt.Sprintf("Test listQuery returned a filter - got (%v) wanted (%s)", query, "{}"))
+		}
+	})
+
+	t.Run("DBRestore : should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		err := conn.DBRestore(context.Background(), DBSCHEMA, "5cc042307ccc69ada893144c")
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test Restore %s returned with error - got (%v) wanted (%s)", "DBRestore", err, "nil"))
+		}
+	})
+
+	t.Run("DBRestore : should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		err := conn.DBRestore(context.Background(), DBSCHEMA, "nada")
+		if !errors.Is(err, ErrInvalidID) {
+			t.Errorf(fmt.Sprintf("Test Restore %s returned - got (%v) wanted (%v)", "DBRestore", err, ErrInvalidID))
+		}
+		err = conn.DBRestore(context.Background(), DBSCHEMA, FAKENOTFOUND)
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test Restore %s returned - got (%v) wanted (%v)", "DBRestore", err, ErrNotFound))
+		}
+	})
+
+	t.Run("DBPurge : should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		n, err := conn.DBPurge(context.Background(), DBSCHEMA, time.Now())
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Purge %s returned with error - got (%v) wanted (%s)", "DBPurge", err, "nil"))
+		}
+		assertEqual(t, n, 2)
+		_, err = conn.DBPurge(context.Background(), "nada", time.Now())
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf(fmt.Sprintf("Test Purge %s returned - got (%v) wanted (%v)", "DBPurge", err, ErrNotFound))
+		}
+	})
+
+	t.Run("Purge : should purge every resource", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		assertEqual(t, Purge(context.Background(), conn, time.Now()), 2*len(Resources()))
+	})
+
+	t.Run("PurgeJob : a 0 retention should disable the purge", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		conn.Config().Purge.RetentionDays = 0
+		// returns straight away even though the ctx is never done
+		PurgeJob(context.Background(), conn)
+	})
+
+	t.Run("PurgeJob : should stop when the context is done", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		PurgeJob(ctx, conn)
+	})
+
+	t.Run("LoadWebhooks : should pass", func(t *testing.T) {
+		defer func() { webhooks = map[string]schema.Webhook{} }()
+		if err := LoadWebhooks("../../tests/webhooks.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Test LoadWebhooks returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		assertEqual(t, len(Webhooks()), 2)
+		assertEqual(t, len(subscribers(schema.ChangeEvent{Resource: DBSCHEMA, Type: "update"})), 2)
+		assertEqual(t, len(subscribers(schema.ChangeEvent{Resource: DBSCHEMA, Type: "restore"})), 1)
+		assertEqual(t, len(subscribers(schema.ChangeEvent{Resource: "publications", Type: "insert"})), 1)
+	})
+
+	t.Run("LoadWebhooks : should fail", func(t *testing.T) {
+		defer func() { webhooks = map[string]schema.Webhook{} }()
+		for _, file := range []string{"../../tests/nada.json", "../../tests/config-parse-error.json", "../../tests/webhooks-error.json"} {
+			if err := LoadWebhooks(file); err == nil {
+				t.Errorf(fmt.Sprintf("Test LoadWebhooks %s returned with no error - got (%s) wanted (%s)", file, "nil", "error"))
+			}
+		}
+		if err := RegisterWebhook(schema.Webhook{Name: "test", URL: "https://test"}); err == nil {
+			t.Errorf(fmt.Sprintf("Test RegisterWebhook without a secret returned with no error - got (%s) wanted (%s)", "nil", "error"))
+		}
+		if err := RegisterWebhook(schema.Webhook{Name: "test", URL: "http://test", Secret: "secret"}); err == nil {
+			t.Errorf(fmt.Sprintf("Test RegisterWebhook with an http url returned with no error - got (%s) wanted (%s)", "nil", "error"))
+		}
+	})
+
+	t.Run("DBInsert : should publish a change event", func(t *testing.T) {
+		RegisterWebhook(schema.Webhook{Name: "test", URL: "https://test", Secret: "secret"})
+		defer func() { webhooks = map[string]schema.Webhook{} }()
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		ctx := logging.WithRequestID(context.Background(), "req-1")
+		if err := conn.DBInsert(ctx, DBSCHEMA, []byte(`{"custom":{"name":"Jane"}}`)); err != nil {
+			t.Fatalf(fmt.Sprintf("Test Insert %s returned with error - got (%v) wanted (%s)", "DBInsert", err, "nil"))
+		}
+		select {
+		case event := <-events:
+			assertEqual(t, event.Type, "insert")
+			assertEqual(t, event.Resource, DBSCHEMA)
+			assertEqual(t, event.RequestID, "req-1")
+			assertEqual(t, event.Document.Custom.Name, "Jane")
+			assertEqual(t, event.DocumentID, event.Document.ID.Hex())
+		default:
+			t.Fatalf(fmt.Sprintf("Test Insert %s published no change event", "DBInsert"))
+		}
+	})
+
+	t.Run("deliver : should sign the event", func(t *testing.T) {
+		hook := schema.Webhook{Name: "test", Secret: "secret"}
+		event := schema.ChangeEvent{ID: "1", Type: "delete", Resource: DBSCHEMA, DocumentID: "5cc042307ccc69ada893144c"}
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.Header.Get(SIGNATURE) != sign("secret", r.Header.Get(TIMESTAMP), body) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Header.Get(EVENTTYPE) != "delete" || r.Header.Get(EVENTID) != "1" {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}))
+		defer srv.Close()
+		hook.URL = srv.URL
+		conn := &Connections{Http: srv.Client(), Redis: &FakeRedis{}, DB: NewFakeClient(), l: logger, cfg: config.Default()}
+		d := deliver(context.Background(), conn, hook, event)
+		assertEqual(t, d.Status, DELIVERED)
+		assertEqual(t, d.Code, http.StatusOK)
+		assertEqual(t, d.Attempts, 1)
+	})
+
+	t.Run("deliver : should only send the document to the webhooks that ask for it", func(t *testing.T) {
+		webhookBackoff = time.Millisecond
+		defer func() { webhookBackoff = time.Second }()
+		bodies := make(chan schema.ChangeEvent, 2)
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event schema.ChangeEvent
+			json.NewDecoder(r.Body).Decode(&event)
+			bodies <- event
+		}))
+		defer srv.Close()
+		// a client that doesn't trust the test server certificate
+		conn := &Connections{Http: &http.Client{}, Redis: &FakeRedis{}, DB: NewFakeClient(), l: logger, cfg: config.Default()}
+		doc := fakeDocument()
+		event := schema.ChangeEvent{ID: "1", Type: "update", Resource: DBSCHEMA, DocumentID: doc.ID.Hex(), Document: &doc}
+		d := deliver(context.Background(), conn, schema.Webhook{Name: "test", URL: srv.URL, Secret: "secret"}, event)
+		assertEqual(t, d.Status, FAILED)
+		conn.Http = srv.Client()
+		deliver(context.Background(), conn, schema.Webhook{Name: "test", URL: srv.URL, Secret: "secret"}, event)
+		if e := <-bodies; e.Document != nil {
+			t.Errorf(fmt.Sprintf("Test deliver document - got (%v) wanted (%v)", e.Document, nil))
+		}
+		deliver(context.Background(), conn, schema.Webhook{Name: "test", URL: srv.URL, Secret: "secret", Document: true}, event)
+		if e := <-bodies; e.Document == nil || e.Document.Custom.Email != doc.Custom.Email {
+			t.Errorf(fmt.Sprintf("Test deliver document - got (%v) wanted (%v)", e.Document, doc))
+		}
+	})
+
+	t.Run("deliver : should retry 5xx and not 4xx", func(t *testing.T) {
+		webhookBackoff = time.Millisecond
+		defer func() { webhookBackoff = time.Second }()
+		codes := map[string][]int{"/retry": {503, 500, 200}, "/fail": {400, 200}}
+		calls := map[string]int{}
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(codes[r.URL.Path][calls[r.URL.Path]])
+			calls[r.URL.Path]++
+		}))
+		defer srv.Close()
+		conn := &Connections{Http: srv.Client(), Redis: &FakeRedis{}, DB: NewFakeClient(), l: logger, cfg: config.Default()}
+		d := deliver(context.Background(), conn, schema.Webhook{Name: "test", URL: srv.URL + "/retry", Secret: "secret"}, schema.ChangeEvent{ID: "1"})
+		assertEqual(t, d.Status, DELIVERED)
+		assertEqual(t, d.Attempts, 3)
+		d = deliver(context.Background(), conn, schema.Webhook{Name: "test", URL: srv.URL + "/fail", Secret: "secret"}, schema.ChangeEvent{ID: "2"})
+		assertEqual(t, d.Status, FAILED)
+		assertEqual(t, d.Code, http.StatusBadRequest)
+		assertEqual(t, d.Attempts, 1)
+		conn.cfg.Webhooks.Retries = 1
+		calls["/retry"] = 0
+		d = deliver(context.Background(), conn, schema.Webhook{Name: "test", URL: srv.URL + "/retry", Secret: "secret"}, schema.ChangeEvent{ID: "3"})
+		assertEqual(t, d.Status, FAILED)
+		assertEqual(t, d.Attempts, 2)
+	})
+
+	t.Run("WebhookJob : should deliver the queued events", func(t *testing.T) {
+		received := make(chan string, 1)
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Get(EVENTID)
+		}))
+		defer srv.Close()
+		RegisterWebhook(schema.Webhook{Name: "test", URL: srv.URL, Secret: "secret", Resources: []string{DBSCHEMA}})
+		defer func() { webhooks = map[string]schema.Webhook{} }()
+		conn := &Connections{Http: srv.Client(), Redis: &FakeRedis{}, DB: NewFakeClient(), l: logger, cfg: config.Default()}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			WebhookJob(ctx, conn)
+			close(done)
+		}()
+		events <- schema.ChangeEvent{ID: "job-1", Type: "insert", Resource: DBSCHEMA}
+		select {
+		case id := <-received:
+			assertEqual(t, id, "job-1")
+		case <-time.After(5 * time.Second):
+			t.Errorf(fmt.Sprintf("Test WebhookJob event %s not delivered", "job-1"))
+		}
+		cancel()
+		<-done
+	})
+
+	t.Run("listQuery : search term should use the text index", func(t *testing.T) {
+		query := listQuery(&schema.ListRange{From: 0, To: 20, Search: " \"john smith\" -test "})
+		text, ok := query["$text"].(bson.M)
+		if !ok {
+			t.Fatalf(fmt.Sprintf("Test listQuery returned no $text filter - got (%v)", query))
+		}
+		assertEqual(t, text["$search"], "\"john smith\" -test")
+	})
+
+	t.Run("textIndex : should cover the custom detail fields", func(t *testing.T) {
+		want := []string{"custom.name", "custom.surname", "custom.email", "custom.title", "custom.address"}
+		index := textIndex(defaultResource)
+		keys := index.Keys.(bson.D)
+		if len(keys) != len(want) {
+			t.Fatalf(fmt.Sprintf("Test textIndex keys - got (%v) wanted (%v)", keys, want))
+		}
+		for x := range want {
+			assertEqual(t, keys[x].Key, want[x])
+			assertEqual(t, keys[x].Value, "text")
+		}
+		assertEqual(t, *index.Options.Name, "customer_text")
+	})
+
+	t.Run("LoadResources : should pass", func(t *testing.T) {
+		err := LoadResources("../../tests/resources.json")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test LoadResources %s returned with error - got (%v) wanted (%s)", "LoadResources", err, "nil"))
+		}
+		res, ok := GetResource("publications")
+		if !ok {
+			t.Fatalf(fmt.Sprintf("Test GetResource %s not registered", "publications"))
+		}
+		assertEqual(t, res.Collection, "publications")
+		assertEqual(t, len(res.TextFields), 2)
+	})
+
+	t.Run("LoadResources : should fail", func(t *testing.T) {
+		for _, file := range []string{"../../tests/nada.json", "../../tests/config-parse-error.json", "../../tests/resources-error.json"} {
+			err := LoadResources(file)
+			if err == nil {
+				t.Errorf(fmt.Sprintf("Test LoadResources %s returned with no error - got (%s) wanted (%s)", file, "nil", "error"))
+			}
+		}
+	})
+
+	t.Run("RegisterResource : collection should default to the name", func(t *testing.T) {
+		err := RegisterResource(schema.Resource{Name: "test"})
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test RegisterResource returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		name, _ := collection("test")
+		assertEqual(t, name, "test")
+	})
+
+	t.Run("DBIndex : all resources should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		LoadResources("../../tests/resources.json")
+		err := conn.(*Connections).DBIndex()
+		if err != nil {
+			t.Errorf(fmt.Sprintf("Test Index %s returned with error - got (%s) wanted (%s)", "DBIndex", "error", "nil"))
+		}
+	})
+
+	t.Run("DBGet : unknown resource should fail", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		_, err := conn.DBGet(context.Background(), "nada", "5cc042307ccc69ada893144c")
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test Get %s returned with no error - got (%s) wanted (%s)", "DBGet", "nil", "error"))
+		}
+		err = conn.DBInsert(context.Background(), "nada", []byte("{}"))
+		if err == nil {
+			t.Errorf(fmt.Sprintf("Test Insert %s returned with no error - got (%s) wanted (%s)", "DBInsert", "nil", "error"))
+		}
+	})
+
 }
@@ -1,24 +1,43 @@
 package connectors
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"time"
 
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
 )
 
+// Clients - the database, cache and http clients used by the handlers
+// The ctx of each call bounds the backend calls, the database operations also have their own deadline (operation.timeout)
 type Clients interface {
+	Config() *config.Config
+	SetConfig(c *config.Config)
 	Error(string, ...interface{})
 	Info(string, ...interface{})
 	Debug(string, ...interface{})
 	Trace(string, ...interface{})
-	DBInsert(body []byte) error
-	DBUpdate(body []byte) (schema.SchemaInterface, error)
-	DBGet(string) (schema.SchemaInterface, error)
-	DBDelete(string) error
-	DBList(*schema.ListRange) ([]schema.SchemaInterface, error)
+	Log(ctx context.Context, operation string) *logging.Logger
+	DBInsert(ctx context.Context, resource string, body []byte) error
+	DBUpdate(ctx context.Context, resource string, body []byte, version int64) (schema.SchemaInterface, error)
+	DBGet(ctx context.Context, resource string, id string) (schema.SchemaInterface, error)
+	DBDelete(ctx context.Context, resource string, id string) error
+	DBRestore(ctx context.Context, resource string, id string) error
+	DBPurge(ctx context.Context, resource string, before time.Time) (int, error)
+	DBHistory(ctx context.Context, resource string, id string, lr *schema.ListRange) ([]schema.AuditEntry, error)
+	DBList(ctx context.Context, resource string, lr *schema.ListRange) ([]schema.SchemaInterface, error)
+	DBCount(ctx context.Context, resource string, lr *schema.ListRange) (int, error)
+	DBBulkInsert(ctx context.Context, resource string, items []json.RawMessage) ([]schema.BulkResult, error)
+	DBBulkUpsert(ctx context.Context, resource string, items []json.RawMessage) ([]schema.BulkResult, error)
+	DBBulkDelete(ctx context.Context, resource string, ids []string) ([]schema.BulkResult, error)
+	DBDelivery(ctx context.Context, d schema.Delivery) error
 	Do(req *http.Request) (*http.Response, error)
-	Get(string) (string, error)
-	Set(string, string, time.Duration) (string, error)
+	Get(context.Context, string) (string, error)
+	Set(context.Context, string, string, time.Duration) (string, error)
+	Del(context.Context, string) error
+	Health(ctx context.Context) []schema.Dependency
 	Close() error
 }
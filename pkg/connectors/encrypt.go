@@ -0,0 +1,92 @@
+package connectors
+
+import (
+	"sync"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/encryption"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const EMAIL string = "email"
+
+var (
+	fieldCipher *encryption.Cipher
+	elck        sync.RWMutex
+)
+
+// SetEncryption - the cipher of the CustomDetail pii fields, nil disables the encryption
+// The fields are encrypted by the inserts and updates and decrypted by the gets and lists
+func SetEncryption(c *encryption.Cipher) {
+	elck.Lock()
+	defer elck.Unlock()
+	fieldCipher = c
+}
+
+// getCipher - private, the current cipher (nil if the encryption is disabled)
+func getCipher() *encryption.Cipher {
+	elck.RLock()
+	defer elck.RUnlock()
+	return fieldCipher
+}
+
+// customFields - private, the CustomDetail fields by json name
+func customFields(c *schema.CustomDetail) map[string]*string {
+	return map[string]*string{
+		"name":    &c.Name,
+		"surname": &c.Surname,
+		"email":   &c.Email,
+		"title":   &c.Title,
+		"mobile":  &c.Mobile,
+		"address": &c.Address,
+	}
+}
+
+// encryptCustom - private, encrypts the configured fields in place
+func encryptCustom(c *schema.CustomDetail) error {
+	ci := getCipher()
+	if ci == nil {
+		return nil
+	}
+	for name, field := range customFields(c) {
+		if !ci.Encrypted(name) {
+			continue
+		}
+		val, err := ci.Encrypt(name, *field)
+		if err != nil {
+			return err
+		}
+		*field = val
+	}
+	return nil
+}
+
+// decryptCustom - private, decrypts the encrypted fields in place, the other fields and the values that are
+// not ciphertexts (i.e. stored before the encryption was enabled) are kept as is
+func decryptCustom(c *schema.CustomDetail) error {
+	ci := getCipher()
+	if ci == nil {
+		return nil
+	}
+	for name, field := range customFields(c) {
+		if !ci.Encrypted(name) || !ci.Sealed(*field) {
+			continue
+		}
+		val, err := ci.Decrypt(name, *field)
+		if err != nil {
+			return err
+		}
+		*field = val
+	}
+	return nil
+}
+
+// emailQuery - private, the custom.email filter, the email ciphertext is the same for a given key
+// so the value is matched against its ciphertext under each key
+func emailQuery(email string) interface{} {
+	ci := getCipher()
+	if ci == nil || !ci.Encrypted(EMAIL) {
+		return email
+	}
+	return bson.M{"$in": ci.Lookup(EMAIL, email)}
+}
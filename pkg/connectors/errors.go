@@ -0,0 +1,52 @@
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// error kinds returned (wrapped) by the crudl operations, use errors.Is to check them
+var (
+	ErrNotFound    = errors.New("document not found")
+	ErrInvalidID   = errors.New("bson ObjectId not valid")
+	ErrConflict    = errors.New("document conflict")
+	ErrValidation  = errors.New("document not valid")
+	ErrUnavailable = errors.New("database unavailable")
+	ErrTimeout     = errors.New("operation timed out")
+)
+
+// dbError - private, wraps a driver error with its error kind (unknown errors are returned as is)
+func dbError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fmt.Errorf("%w : %v", ErrNotFound, err)
+	case mongo.IsDuplicateKeyError(err):
+		return fmt.Errorf("%w : %v", ErrConflict, err)
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return fmt.Errorf("%w : %v", ErrValidation, err)
+	case errors.Is(err, context.Canceled), mongo.IsTimeout(err):
+		return fmt.Errorf("%w : %v", ErrTimeout, err)
+	case errors.Is(err, io.EOF), errors.Is(err, mongo.ErrClientDisconnected), mongo.IsNetworkError(err), errors.As(err, &netErr), isUnreachable(err):
+		return fmt.Errorf("%w : %v", ErrUnavailable, err)
+	}
+	return err
+}
+
+// isUnreachable - private, the driver reports server selection failures with an internal error type
+func isUnreachable(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "server selection error") || strings.Contains(msg, "no reachable servers") || strings.Contains(msg, "connection reset")
+}
@@ -0,0 +1,41 @@
+package connectors
+
+import (
+	"context"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	HEALTH string = "Health : "
+	UP     string = "UP"
+	DOWN   string = "DOWN"
+)
+
+// Health pings mongodb and redis and reports the status and latency of each one
+func (r *Connections) Health(ctx context.Context) []schema.Dependency {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	log := r.log(ctx, HEALTH, "", "")
+	return []schema.Dependency{
+		r.ping(ctx, log, "mongodb", func(ctx context.Context) error { return r.DB.Ping(ctx, readpref.Primary()) }),
+		r.ping(ctx, log, "redis", r.redisPing),
+	}
+}
+
+// ping - private, times a dependency ping
+func (r *Connections) ping(ctx context.Context, log *opLog, name string, fn func(context.Context) error) schema.Dependency {
+	start := time.Now()
+	err := fn(ctx)
+	dep := schema.Dependency{Name: name, Status: UP, LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		log.Error("%s %v", name, err)
+		dep.Status = DOWN
+		dep.Message = err.Error()
+		return dep
+	}
+	log.Trace("%s %.3fms", name, dep.LatencyMs)
+	return dep
+}
@@ -0,0 +1,76 @@
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/alerts"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+)
+
+// opLog - the log lines of a connector operation, each one carries the request id (from the ctx),
+// the operation, the resource and the document id as json fields
+type opLog struct {
+	l         *logging.Logger
+	start     time.Time
+	operation string
+	resource  string
+}
+
+// Log - the logger of a request, its lines carry the operation and the request id (from the ctx) as json fields
+func (r *Connections) Log(ctx context.Context, operation string) *logging.Logger {
+	fields := []interface{}{"operation", operation}
+	if rid := logging.RequestID(ctx); rid != "" {
+		fields = append(fields, "requestid", rid)
+	}
+	return r.l.With(fields...)
+}
+
+// log - private, the logger of an operation, op is one of the DBINSERT... prefixes
+func (r *Connections) log(ctx context.Context, op string, resource string, id string) *opLog {
+	operation := strings.TrimSuffix(op, " : ")
+	fields := []interface{}{"operation", operation}
+	if resource != "" {
+		fields = append(fields, "resource", resource)
+	}
+	if id != "" {
+		fields = append(fields, "id", id)
+	}
+	if rid := logging.RequestID(ctx); rid != "" {
+		fields = append(fields, "requestid", rid)
+	}
+	return &opLog{l: r.l.With(fields...), start: time.Now(), operation: operation, resource: resource}
+}
+
+func (o *opLog) Error(msg string, val ...interface{}) {
+	o.l.Error(fmt.Sprintf(msg, val...))
+}
+
+func (o *opLog) Info(msg string, val ...interface{}) {
+	o.l.Info(fmt.Sprintf(msg, val...))
+}
+
+func (o *opLog) Debug(msg string, val ...interface{}) {
+	o.l.Debug(fmt.Sprintf(msg, val...))
+}
+
+func (o *opLog) Trace(msg string, val ...interface{}) {
+	o.l.Trace(fmt.Sprintf(msg, val...))
+}
+
+// done - logs the outcome and duration of the operation and returns err, a slow operation on a resource fires an alert
+func (o *opLog) done(err error) error {
+	d := time.Since(o.start)
+	if o.resource != "" {
+		alerts.Query(o.operation, o.resource, d)
+	}
+	ms := float64(d.Microseconds()) / 1000
+	if err != nil {
+		o.l.Error(err.Error(), "durationms", ms)
+		return err
+	}
+	o.l.Debug("completed", "durationms", ms)
+	return nil
+}
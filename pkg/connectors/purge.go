@@ -0,0 +1,45 @@
+package connectors
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	PURGE string = "Purge : "
+)
+
+// Purge hard deletes the documents of every resource soft deleted before the given time, returns the number deleted
+func Purge(ctx context.Context, conn Clients, before time.Time) int {
+	total := 0
+	for _, res := range Resources() {
+		n, err := conn.DBPurge(ctx, res.Name, before)
+		if err != nil {
+			conn.Error(PURGE+"%s %v\n", res.Name, err)
+			continue
+		}
+		total += n
+	}
+	return total
+}
+
+// PurgeJob runs the purge every purge.interval seconds until the ctx is done (a running purge is finished first),
+// the documents soft deleted more than purge.retentiondays ago are deleted (0 disables the purge)
+func PurgeJob(ctx context.Context, conn Clients) {
+	retention := time.Duration(conn.Config().Purge.RetentionDays) * 24 * time.Hour
+	if retention == 0 {
+		conn.Info(PURGE + "disabled\n")
+		return
+	}
+	ticker := time.NewTicker(time.Duration(conn.Config().Purge.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n := Purge(ctx, conn, time.Now().Add(-retention))
+			conn.Info(PURGE+"%d documents deleted more than %v ago\n", n, retention)
+		}
+	}
+}
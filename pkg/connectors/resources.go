@@ -0,0 +1,102 @@
+package connectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+)
+
+// the customer resource is always available and is used by the /api/v1/object(s) routes
+var defaultResource = schema.Resource{
+	Name:       DBSCHEMA,
+	Collection: DBSCHEMA,
+	Schema:     "schemas/customer.json",
+	TextFields: []string{"custom.name", "custom.surname", "custom.email", "custom.title", "custom.address"},
+}
+
+var (
+	resources = map[string]schema.Resource{DBSCHEMA: defaultResource}
+	rlck      sync.RWMutex
+)
+
+// RegisterResource adds (or replaces) a named resource
+func RegisterResource(res schema.Resource) error {
+	if res.Name == "" {
+		return errors.New("resource name is mandatory")
+	}
+	if res.Collection == "" {
+		res.Collection = res.Name
+	}
+	rlck.Lock()
+	defer rlck.Unlock()
+	resources[res.Name] = res
+	return nil
+}
+
+// LoadResources registers all the resources defined in a json file (an array of schema.Resource)
+func LoadResources(file string) error {
+	var list []schema.Resource
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("resources file %s : %v", file, err)
+	}
+	for _, res := range list {
+		if err = RegisterResource(res); err != nil {
+			return fmt.Errorf("resources file %s : %v", file, err)
+		}
+	}
+	return nil
+}
+
+// GetResource looks up a registered resource by name
+func GetResource(name string) (schema.Resource, bool) {
+	rlck.RLock()
+	defer rlck.RUnlock()
+	res, ok := resources[name]
+	return res, ok
+}
+
+// Resources returns all the registered resources
+func Resources() []schema.Resource {
+	rlck.RLock()
+	defer rlck.RUnlock()
+	list := make([]schema.Resource, 0, len(resources))
+	for _, res := range resources {
+		list = append(list, res)
+	}
+	return list
+}
+
+// collection - private, maps a resource name to its collection
+func collection(name string) (string, error) {
+	res, ok := GetResource(name)
+	if !ok {
+		return "", fmt.Errorf("%w : resource %s not registered", ErrNotFound, name)
+	}
+	return res.Collection, nil
+}
+
+// decode - private, unmarshals a request body into a document, the resources without a json schema can only have
+// the fields of schema.SchemaInterface (the others go in data) so an unknown field is rejected rather than dropped
+func decode(resource string, body []byte, data interface{}) error {
+	if err := json.Unmarshal(body, data); err != nil {
+		return err
+	}
+	if res, _ := GetResource(resource); res.Schema != "" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(data); err != nil {
+		return fmt.Errorf("%w : %v", ErrValidation, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// the delay between the retries of a read that failed because the database was unreachable
+	RETRYBACKOFF time.Duration = 100 * time.Millisecond
+	RETRYMAX     time.Duration = 2 * time.Second
+)
+
+// backoff - private, the exponential delay before the given (0 based) attempt, capped at max
+func backoff(attempt int, base time.Duration, max time.Duration) time.Duration {
+	if attempt > 30 {
+		return max
+	}
+	d := base << uint(attempt)
+	if d <= 0 || d > max {
+		d = max
+	}
+	return d
+}
+
+// retry - private, reruns a read while the database is unavailable (the driver reconnects in the background)
+// (operation.retries times, 0 disables them), fn returns the dbError mapped error, once the ctx is done the last error is returned
+// writes are not retried here, the driver retries them once (retryable writes) without duplicating them
+func (r *Connections) retry(ctx context.Context, log *opLog, fn func() error) error {
+	err := fn()
+	for attempt := 0; attempt < r.Config().Operation.Retries && errors.Is(err, ErrUnavailable); attempt++ {
+		delay := backoff(attempt, RETRYBACKOFF, RETRYMAX)
+		log.Info("retrying in %v after %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+package connectors
+
+import (
+	"context"
+	"time"
+)
+
+// withTimeout - private, bounds a database operation by the operation deadline (operation.timeout seconds)
+// or the ctx deadline if it's earlier
+func (r *Connections) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(r.Config().Operation.Timeout)*time.Second)
+}
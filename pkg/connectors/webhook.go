@@ -0,0 +1,270 @@
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	WEBHOOK    string = "Webhook : "
+	DBDELIVERY string = "DBDelivery : "
+	DELIVERED  string = "delivered"
+	FAILED     string = "failed"
+	// the signature is the hex HMAC-SHA256 of "<timestamp>.<body>" with the webhook secret
+	SIGNATURE string = "X-Webhook-Signature"
+	TIMESTAMP string = "X-Webhook-Timestamp"
+	EVENTID   string = "X-Webhook-ID"
+	EVENTTYPE string = "X-Webhook-Event"
+	// the queued events are delivered by webhooks.workers workers, each delivery is retried webhooks.retries times
+	WEBHOOKQUEUE int = 1000
+	WEBHOOKMAX       = 30 * time.Second
+)
+
+// the event type of each mutation
+var eventTypes = map[string]string{
+	DBINSERT:     "insert",
+	DBBULKINSERT: "insert",
+	DBUPDATE:     "update",
+	DBBULKUPSERT: "update",
+	DBDELETE:     "delete",
+	DBBULKDELETE: "delete",
+	DBRESTORE:    "restore",
+}
+
+var (
+	webhooks = map[string]schema.Webhook{}
+	wlck     sync.RWMutex
+	events   = make(chan schema.ChangeEvent, WEBHOOKQUEUE)
+	// the first retry waits webhookBackoff, doubling up to WEBHOOKMAX
+	webhookBackoff = time.Second
+)
+
+// RegisterWebhook adds (or replaces) a named webhook
+func RegisterWebhook(hook schema.Webhook) error {
+	if hook.Name == "" {
+		return errors.New("webhook name is mandatory")
+	}
+	u, err := url.Parse(hook.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook %s url %q is not valid (https is required)", hook.Name, hook.URL)
+	}
+	if hook.Secret == "" {
+		return fmt.Errorf("webhook %s secret is mandatory", hook.Name)
+	}
+	wlck.Lock()
+	defer wlck.Unlock()
+	webhooks[hook.Name] = hook
+	return nil
+}
+
+// LoadWebhooks registers all the webhooks defined in a json file (an array of schema.Webhook)
+func LoadWebhooks(file string) error {
+	var list []schema.Webhook
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("webhooks file %s : %v", file, err)
+	}
+	for _, hook := range list {
+		if err = RegisterWebhook(hook); err != nil {
+			return fmt.Errorf("webhooks file %s : %v", file, err)
+		}
+	}
+	return nil
+}
+
+// Webhooks returns all the registered webhooks
+func Webhooks() []schema.Webhook {
+	wlck.RLock()
+	defer wlck.RUnlock()
+	list := make([]schema.Webhook, 0, len(webhooks))
+	for _, hook := range webhooks {
+		list = append(list, hook)
+	}
+	return list
+}
+
+// matches - private, checks if the list is empty or has the value or "*"
+func matches(list []string, val string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == val || item == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribers - private, the webhooks of the event resource and type
+func subscribers(event schema.ChangeEvent) []schema.Webhook {
+	var list []schema.Webhook
+	for _, hook := range Webhooks() {
+		if matches(hook.Resources, event.Resource) && matches(hook.Events, event.Type) {
+			list = append(list, hook)
+		}
+	}
+	return list
+}
+
+// publish - private, queues the change events of the mutations, the events are dropped (and logged)
+// when the queue is full so that a slow webhook never blocks the crudl operations
+func publish(ctx context.Context, log *opLog, op string, resource string, mutations ...mutation) {
+	if len(Webhooks()) == 0 {
+		return
+	}
+	for _, m := range mutations {
+		event := schema.ChangeEvent{
+			ID:         primitive.NewObjectID().Hex(),
+			Type:       eventTypes[op],
+			Resource:   resource,
+			DocumentID: m.id,
+			Document:   m.doc,
+			Timestamp:  time.Now().UnixNano(),
+			RequestID:  logging.RequestID(ctx),
+		}
+		select {
+		case events <- event:
+		default:
+			log.Error("webhook queue full, %s event %s of %s dropped", event.Type, event.ID, m.id)
+		}
+	}
+}
+
+// sign - private, the signature of the body sent at the timestamp
+func sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post - private, posts the signed event, a network error, a 429 or a 5xx can be retried
+func post(ctx context.Context, conn Clients, hook schema.Webhook, event schema.ChangeEvent, body []byte) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(conn.Config().Webhooks.Timeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(CONTENTTYPE, APPLICATIONJSON)
+	req.Header.Set(TIMESTAMP, ts)
+	req.Header.Set(SIGNATURE, sign(hook.Secret, ts, body))
+	req.Header.Set(EVENTID, event.ID)
+	req.Header.Set(EVENTTYPE, event.Type)
+	if event.RequestID != "" {
+		req.Header.Set(logging.REQUESTID, event.RequestID)
+	}
+	resp, err := conn.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp.StatusCode, false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return resp.StatusCode, retry, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// deliver - private, posts the event to the webhook with retries and writes the outcome to the delivery log
+func deliver(ctx context.Context, conn Clients, hook schema.Webhook, event schema.ChangeEvent) schema.Delivery {
+	d := schema.Delivery{Webhook: hook.Name, URL: hook.URL, EventID: event.ID, Type: event.Type, Resource: event.Resource, DocumentID: event.DocumentID}
+	// the document has the decrypted customer fields, it's only sent to the webhooks that ask for it
+	if !hook.Document {
+		event.Document = nil
+	}
+	body, _ := json.Marshal(event)
+	code, retry, err := post(ctx, conn, hook, event, body)
+	d.Attempts = 1
+	for attempt := 0; attempt < conn.Config().Webhooks.Retries && retry; attempt++ {
+		delay := backoff(attempt, webhookBackoff, WEBHOOKMAX)
+		conn.Debug(WEBHOOK+"%s event %s retrying in %v after %v\n", hook.Name, event.ID, delay, err)
+		select {
+		case <-ctx.Done():
+			retry = false
+			continue
+		case <-time.After(delay):
+		}
+		code, retry, err = post(ctx, conn, hook, event, body)
+		d.Attempts++
+	}
+	d.Code, d.Status = code, DELIVERED
+	if err != nil {
+		d.Status, d.Error = FAILED, err.Error()
+	}
+	d.Timestamp = time.Now().UnixNano()
+	if d.Status == FAILED {
+		conn.Error(WEBHOOK+"%s event %s not delivered after %d attempts %s\n", hook.Name, event.ID, d.Attempts, d.Error)
+	}
+	// the delivery is logged even if the job is stopping
+	lctx := logging.WithRequestID(context.Background(), event.RequestID)
+	if err := conn.DBDelivery(lctx, d); err != nil {
+		conn.Error(WEBHOOK+"%s event %s delivery log not written %v\n", hook.Name, event.ID, err)
+	}
+	return d
+}
+
+// WebhookJob delivers the change events to the webhooks until the ctx is done, webhooks.workers
+// events are delivered concurrently (the events still queued when the ctx is done are not delivered), it returns
+// once the running deliveries are logged
+func WebhookJob(ctx context.Context, conn Clients) {
+	if len(Webhooks()) == 0 {
+		conn.Info(WEBHOOK + "no webhooks registered\n")
+		return
+	}
+	workers := conn.Config().Webhooks.Workers
+	if workers == 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-events:
+					for _, hook := range subscribers(event) {
+						deliver(ctx, conn, hook, event)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// DBDelivery writes a webhook delivery to the delivery log
+func (r *Connections) DBDelivery(ctx context.Context, d schema.Delivery) error {
+	log := r.log(ctx, DBDELIVERY, d.Resource, d.DocumentID)
+	c := r.DB.Database(r.Config().MongoDB.Database).Collection(r.Config().Webhooks.Collection)
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	_, err := c.InsertOne(ctx, d)
+	return log.done(dbError(err))
+}
@@ -0,0 +1,217 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+)
+
+const (
+	// PREFIX - encrypted values are stored as enc:<key id>:<base64url nonce and ciphertext>
+	PREFIX  string = "enc:"
+	KEYSIZE int    = 32
+	// the key id of ENCRYPTION_KEY
+	ENVKEY string = "env"
+)
+
+// DEFAULTFIELDS - the CustomDetail fields encrypted when the fields are not configured
+var DEFAULTFIELDS = []string{"name", "surname", "email", "mobile", "address"}
+
+// DETERMINISTIC - fields encrypted with a nonce derived from the value so that equal values
+// have the same ciphertext and can be looked up
+var DETERMINISTIC = map[string]bool{"email": true}
+
+// key - the aes-gcm cipher and the hmac key used to derive the deterministic nonces
+type key struct {
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// Cipher - encrypts the configured fields with the current key and decrypts with any of the keys (rotation)
+type Cipher struct {
+	current string
+	keys    map[string]key
+	fields  map[string]bool
+}
+
+// KeyFile - the ENCRYPTION_KEYFILE format, keys are base64 encoded 32 byte secrets
+type KeyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	Fields  []string          `json:"fields,omitempty"`
+}
+
+// New - a cipher encrypting the fields with the current key, keys are 32 byte secrets by key id
+func New(current string, keys map[string][]byte, fields []string) (*Cipher, error) {
+	c := &Cipher{current: current, keys: make(map[string]key)}
+	for kid, secret := range keys {
+		if kid == "" || strings.Contains(kid, ":") {
+			return nil, fmt.Errorf("key id %q not valid", kid)
+		}
+		if len(secret) != KEYSIZE {
+			return nil, fmt.Errorf("key %s must be %d bytes", kid, KEYSIZE)
+		}
+		// separate keys for the encryption and the nonce derivation
+		block, _ := aes.NewCipher(derive(secret, "aes-gcm"))
+		aead, _ := cipher.NewGCM(block)
+		c.keys[kid] = key{aead: aead, mac: derive(secret, "nonce")}
+	}
+	if _, ok := c.keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q not found", current)
+	}
+	c.setFields(fields)
+	return c, nil
+}
+
+// setFields - private, the encrypted fields (the default ones if empty)
+func (c *Cipher) setFields(fields []string) {
+	if len(fields) == 0 {
+		fields = DEFAULTFIELDS
+	}
+	c.fields = make(map[string]bool)
+	for _, field := range fields {
+		c.fields[strings.TrimSpace(field)] = true
+	}
+}
+
+// Load - a cipher from a json key file (see KeyFile)
+func Load(file string) (*Cipher, error) {
+	var kf KeyFile
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("key file %s : %v", file, err)
+	}
+	keys := make(map[string][]byte)
+	for kid, val := range kf.Keys {
+		if keys[kid], err = base64.StdEncoding.DecodeString(val); err != nil {
+			return nil, fmt.Errorf("key file %s key %s : %v", file, kid, err)
+		}
+	}
+	c, err := New(kf.Current, keys, kf.Fields)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s : %v", file, err)
+	}
+	return c, nil
+}
+
+// FromConfig - a cipher from the key file or the key (a base64 32 byte secret), the configured fields
+// override the fields, returns nil if no key is set (no encryption)
+func FromConfig(c config.Encryption) (*Cipher, error) {
+	fields := c.Fields
+	if len(fields) == 0 {
+		fields = nil
+	}
+	if c.KeyFile != "" {
+		kc, err := Load(c.KeyFile)
+		if err == nil && fields != nil {
+			kc.setFields(fields)
+		}
+		return kc, err
+	}
+	if c.Key == "" {
+		return nil, nil
+	}
+	secret, err := base64.StdEncoding.DecodeString(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key : %v", err)
+	}
+	return New(ENVKEY, map[string][]byte{ENVKEY: secret}, fields)
+}
+
+// derive - private, a sub key of the secret for the purpose
+func derive(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// Encrypted - true if the field is encrypted
+func (c *Cipher) Encrypted(field string) bool {
+	return c.fields[field]
+}
+
+// Encrypt - the ciphertext of a field value with the current key, empty values are kept as is
+// the field name is authenticated so that a value can't be moved to another field
+func (c *Cipher) Encrypt(field string, val string) (string, error) {
+	if val == "" {
+		return val, nil
+	}
+	return c.seal(c.current, field, val)
+}
+
+// Lookup - the ciphertexts of a deterministic field value under each key, used to query the field
+func (c *Cipher) Lookup(field string, val string) []string {
+	var list []string
+	for kid := range c.keys {
+		if s, err := c.seal(kid, field, val); err == nil {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// seal - private, deterministic fields use a nonce derived from the field and value (synthetic iv)
+func (c *Cipher) seal(kid string, field string, val string) (string, error) {
+	k := c.keys[kid]
+	nonce := make([]byte, k.aead.NonceSize())
+	if DETERMINISTIC[field] {
+		m := hmac.New(sha256.New, k.mac)
+		m.Write([]byte(field + ":" + val))
+		copy(nonce, m.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := k.aead.Seal(nonce, nonce, []byte(val), []byte(field))
+	return PREFIX + kid + ":" + base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// Sealed - true if the value has the format of a ciphertext of one of the keys (enc:<key id>:<nonce and
+// ciphertext>), any other value (i.e. a plaintext that starts with enc:) is not a ciphertext
+func (c *Cipher) Sealed(val string) bool {
+	if !strings.HasPrefix(val, PREFIX) {
+		return false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(val, PREFIX), ":", 2)
+	k, ok := c.keys[parts[0]]
+	if !ok || len(parts) != 2 {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	return err == nil && len(b) >= k.aead.NonceSize()+k.aead.Overhead()
+}
+
+// Decrypt - the plaintext of a field value, values that are not encrypted (i.e. stored before
+// the encryption was enabled) are returned as is
+func (c *Cipher) Decrypt(field string, val string) (string, error) {
+	if !strings.HasPrefix(val, PREFIX) {
+		return val, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(val, PREFIX), ":", 2)
+	k, ok := c.keys[parts[0]]
+	if !ok || len(parts) != 2 {
+		return "", fmt.Errorf("field %s : no key %q", field, parts[0])
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(b) < k.aead.NonceSize() {
+		return "", fmt.Errorf("field %s : ciphertext not valid", field)
+	}
+	n := k.aead.NonceSize()
+	out, err := k.aead.Open(nil, b[:n], b[n:], []byte(field))
+	if err != nil {
+		return "", errors.New("field " + field + " : decryption failed")
+	}
+	return string(out), nil
+}
@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
+)
+
+func assertEqual(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+
+	old := bytes.Repeat([]byte{1}, KEYSIZE)
+	current := bytes.Repeat([]byte{2}, KEYSIZE)
+	c, err := New("k2", map[string][]byte{"k1": old, "k2": current}, nil)
+	if err != nil {
+		t.Fatalf(fmt.Sprintf("Test New returned with error - got (%v) wanted (%s)", err, "nil"))
+	}
+
+	t.Run("Encrypt : should round trip", func(t *testing.T) {
+		for _, field := range []string{"name", "email"} {
+			enc, err := c.Encrypt(field, "Jane Doe")
+			if err != nil || !strings.HasPrefix(enc, PREFIX+"k2:") || strings.Contains(enc, "Jane") {
+				t.Fatalf(fmt.Sprintf("Test Encrypt %s returned - got (%s, %v)", field, enc, err))
+			}
+			dec, err := c.Decrypt(field, enc)
+			assertEqual(t, err, nil)
+			assertEqual(t, dec, "Jane Doe")
+		}
+	})
+
+	t.Run("Encrypt : only the email should be deterministic", func(t *testing.T) {
+		a, _ := c.Encrypt("email", "jane@test.com")
+		b, _ := c.Encrypt("email", "jane@test.com")
+		assertEqual(t, a, b)
+		other, _ := c.Encrypt("email", "john@test.com")
+		assertEqual(t, a != other, true)
+		a, _ = c.Encrypt("name", "Jane")
+		b, _ = c.Encrypt("name", "Jane")
+		assertEqual(t, a != b, true)
+	})
+
+	t.Run("Encrypt : empty values should be kept", func(t *testing.T) {
+		enc, err := c.Encrypt("name", "")
+		assertEqual(t, enc, "")
+		assertEqual(t, err, nil)
+	})
+
+	t.Run("Lookup : should match the ciphertext of each key", func(t *testing.T) {
+		prev, _ := New("k1", map[string][]byte{"k1": old}, nil)
+		stored, _ := prev.Encrypt("email", "jane@test.com")
+		current, _ := c.Encrypt("email", "jane@test.com")
+		list := c.Lookup("email", "jane@test.com")
+		assertEqual(t, len(list), 2)
+		assertEqual(t, strings.Contains(strings.Join(list, " "), stored), true)
+		assertEqual(t, strings.Contains(strings.Join(list, " "), current), true)
+		// a rotated cipher still decrypts the old values
+		dec, err := c.Decrypt("email", stored)
+		assertEqual(t, err, nil)
+		assertEqual(t, dec, "jane@test.com")
+	})
+
+	t.Run("Decrypt : plaintext values should be returned as is", func(t *testing.T) {
+		dec, err := c.Decrypt("name", "Jane")
+		assertEqual(t, err, nil)
+		assertEqual(t, dec, "Jane")
+	})
+
+	t.Run("Sealed : should check the key id and format", func(t *testing.T) {
+		enc, _ := c.Encrypt("name", "Jane")
+		assertEqual(t, c.Sealed(enc), true)
+		for _, val := range []string{"Jane", "enc:x", PREFIX + "k3:" + strings.TrimPrefix(enc, PREFIX+"k2:"), PREFIX + "k2:!!", PREFIX + "k2:AAAA"} {
+			assertEqual(t, c.Sealed(val), false)
+		}
+	})
+
+	t.Run("Decrypt : tampered values should fail", func(t *testing.T) {
+		enc, _ := c.Encrypt("name", "Jane")
+		// the field name is authenticated
+		if _, err := c.Decrypt("surname", enc); err == nil {
+			t.Errorf(fmt.Sprintf("Test Decrypt %s returned with no error - got (%s) wanted (%s)", "other field", "nil", "error"))
+		}
+		b, _ := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(enc, PREFIX+"k2:"))
+		b[len(b)-1] ^= 1
+		for name, val := range map[string]string{
+			"flipped bit": PREFIX + "k2:" + base64.RawURLEncoding.EncodeToString(b),
+			"unknown key": strings.Replace(enc, ":k2:", ":k3:", 1),
+			"no key":      PREFIX + "nada",
+			"bad base64":  PREFIX + "k2:!!",
+			"too short":   PREFIX + "k2:AAAA",
+		} {
+			if _, err := c.Decrypt("name", val); err == nil {
+				t.Errorf(fmt.Sprintf("Test Decrypt %s returned with no error - got (%s) wanted (%s)", name, "nil", "error"))
+			}
+		}
+	})
+
+	t.Run("New : should check the keys", func(t *testing.T) {
+		for name, keys := range map[string]map[string][]byte{
+			"short key":   {"k2": []byte("short")},
+			"no current":  {"k1": old},
+			"bad key id":  {"k2": current, "k:3": current},
+			"empty keyid": {"k2": current, "": current},
+		} {
+			if _, err := New("k2", keys, nil); err == nil {
+				t.Errorf(fmt.Sprintf("Test New %s returned with no error - got (%s) wanted (%s)", name, "nil", "error"))
+			}
+		}
+		assertEqual(t, c.Encrypted("email"), true)
+		assertEqual(t, c.Encrypted("title"), false)
+	})
+
+	t.Run("Load : should pass", func(t *testing.T) {
+		kc, err := Load("../../tests/keys.json")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test Load returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		assertEqual(t, kc.current, "2020-05")
+		assertEqual(t, len(kc.keys), 2)
+	})
+
+	t.Run("Load : should fail", func(t *testing.T) {
+		for _, file := range []string{"../../tests/nada.json", "../../tests/config-parse-error.json", "../../tests/keys-error.json"} {
+			if _, err := Load(file); err == nil {
+				t.Errorf(fmt.Sprintf("Test Load %s returned with no error - got (%s) wanted (%s)", file, "nil", "error"))
+			}
+		}
+	})
+
+	t.Run("FromConfig : should read the keyfile or the key", func(t *testing.T) {
+		kc, err := FromConfig(config.Encryption{})
+		if kc != nil || err != nil {
+			t.Errorf(fmt.Sprintf("Test FromConfig returned - got (%v, %v) wanted (%s)", kc, err, "nil"))
+		}
+		c := config.Encryption{Key: base64.StdEncoding.EncodeToString(current), Fields: []string{"email", "mobile"}}
+		kc, err = FromConfig(c)
+		assertEqual(t, err, nil)
+		assertEqual(t, kc.current, ENVKEY)
+		assertEqual(t, kc.Encrypted("name"), false)
+		assertEqual(t, kc.Encrypted("mobile"), true)
+		c.KeyFile = "../../tests/keys.json"
+		kc, err = FromConfig(c)
+		assertEqual(t, err, nil)
+		assertEqual(t, kc.Encrypted("name"), false)
+		if _, err = FromConfig(config.Encryption{Key: "nada!"}); err == nil {
+			t.Errorf(fmt.Sprintf("Test FromConfig returned with no error - got (%s) wanted (%s)", "nil", "error"))
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/auth"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/connectors"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"github.com/gorilla/mux"
+)
+
+var (
+	AUTHORIZATION   string = "Authorization"
+	APIKEY          string = "X-API-Key"
+	WWWAUTHENTICATE string = "WWW-Authenticate"
+)
+
+// Authenticate - the bearer token (or api key) middleware of the crudl routes, requests without valid credentials get a 401
+// The verified claims are added to the request ctx (see auth.FromContext), with a nil verifier and
+// no api keys in the policy the requests are not authenticated
+func Authenticate(v *auth.Verifier, conn connectors.Clients) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if v == nil && !auth.HasAPIKeys() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rid := requestID(r)
+			r = r.WithContext(logging.WithRequestID(r.Context(), rid))
+			claims, err := credentials(v, r)
+			if err == nil {
+				conn.Log(r.Context(), "Authenticate").Debug(fmt.Sprintf("MW auth authenticated %s", claims.Subject()))
+				next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+				return
+			}
+			conn.Log(r.Context(), "Authenticate").Error(fmt.Sprintf("MW auth %v", err))
+			w.Header().Set(logging.REQUESTID, rid)
+			w.Header().Set(CONTENTTYPE, APPLICATIONJSON)
+			w.Header().Set(WWWAUTHENTICATE, `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			// the reason stays in the log, the caller only needs to know the token was refused
+			response := &schema.Response{Code: http.StatusUnauthorized, StatusCode: strconv.Itoa(http.StatusUnauthorized), Status: "KO", Message: "MW auth bearer token or api key missing or not valid\n", RequestID: rid}
+			b, _ := json.MarshalIndent(response, "", "	")
+			fmt.Fprintf(w, string(b))
+		})
+	}
+}
+
+// credentials - private, the claims of the X-API-Key or of the bearer token
+func credentials(v *auth.Verifier, r *http.Request) (auth.Claims, error) {
+	if key := r.Header.Get(APIKEY); key != "" {
+		if claims, ok := auth.APIKeyClaims(key); ok {
+			return claims, nil
+		}
+		return nil, fmt.Errorf("%w : api key not valid", auth.ErrUnauthenticated)
+	}
+	token, err := auth.BearerToken(r.Header.Get(AUTHORIZATION))
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return nil, fmt.Errorf("%w : bearer tokens are not accepted", auth.ErrUnauthenticated)
+	}
+	return v.Verify(token)
+}
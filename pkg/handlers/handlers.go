@@ -1,16 +1,23 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/alerts"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/auth"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/connectors"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/metrics"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/validator"
 	"github.com/gorilla/mux"
 )
 
@@ -21,25 +28,78 @@ var (
 	FROM            string = "from"
 	TO              string = "to"
 	SEARCH          string = "search"
+	RESOURCE        string = "resource"
+	ETAG            string = "ETag"
+	IFMATCH         string = "If-Match"
+	CURSOR          string = "cursor"
+	LIMIT           string = "limit"
+	EMAIL           string = "email"
+	INCLUDEDELETED  string = "includeDeleted"
+	SYSCONFIG       string = "SysConfig"
+	CONFIG          string = "config"
+	PAGESIZE        int    = 20
+	MAXPAGESIZE     int    = 1000
+	BULKLIMIT       int    = 10000
 )
 
-// IsAlive - liveliness and readiness probe check
-func IsAlive(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "{\"isalive\": true , \"version\": \""+os.Getenv("VERSION")+"\"}\n")
+// IsAlive - liveness probe check
+func IsAlive(w http.ResponseWriter, r *http.Request, conn connectors.Clients) {
+	fmt.Fprintf(w, "{\"isalive\": true , \"version\": \""+version(conn)+"\"}\n")
 }
 
-// Send CustomAlert - via slack
-//func SendAlert(msg []byte, conn connectors.Clients) error {
-//	req, err := http.NewRequest("POST", os.Getenv("SLACK_URL"), bytes.NewBuffer(msg))
-//	conn.Debug("SendAlert URL info", os.Getenv("SLACK_URL"))
-//	resp, err := conn.Do(req)
-//	if err != nil || resp.StatusCode != 200 {
-//		conn.Error("SendAlert Failed ", err)
-//		return err
-//	}
-//	conn.Info("SendAlert sent successfully", nil)
-//	return nil
-//}
+// version - private, the service version of the config (empty if the client connections could not be initialised)
+func version(conn connectors.Clients) string {
+	if conn == nil {
+		return ""
+	}
+	return conn.Config().Version
+}
+
+// IsReady - readiness probe check, pings mongodb and redis and returns a 503 if any of them is down
+// (or if the client connections could not be initialised)
+func IsReady(w http.ResponseWriter, r *http.Request, conn connectors.Clients) {
+	response := &schema.Readiness{Ready: true, Version: version(conn)}
+	if conn == nil {
+		response.Ready = false
+		response.Dependencies = []schema.Dependency{
+			{Name: "mongodb", Status: connectors.DOWN, Message: "client connections not initialised"},
+			{Name: "redis", Status: connectors.DOWN, Message: "client connections not initialised"},
+		}
+	} else {
+		response.Dependencies = conn.Health(r.Context())
+		alerts.Health(response.Dependencies)
+	}
+	for _, dep := range response.Dependencies {
+		if dep.Status != connectors.UP {
+			response.Ready = false
+		}
+	}
+	w.Header().Set(CONTENTTYPE, APPLICATIONJSON)
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	b, _ := json.MarshalIndent(response, "", "	")
+	fmt.Fprintf(w, string(b))
+}
+
+// EffectiveConfig - the settings the service is running with (the reloaded ones included), the secrets are redacted
+// with a policy the caller needs the SysConfig operation on the config resource
+func EffectiveConfig(w http.ResponseWriter, r *http.Request, conn connectors.Clients) {
+	w.Header().Set(CONTENTTYPE, APPLICATIONJSON)
+	claims, _ := auth.FromContext(r.Context())
+	if err := auth.Authorize(claims, SYSCONFIG, CONFIG); err != nil {
+		rid := requestID(r)
+		log := conn.Log(logging.WithRequestID(r.Context(), rid), SYSCONFIG)
+		response, _ := handleError(log, SYSCONFIG, http.StatusOK, nil, err)
+		response.RequestID = rid
+		w.WriteHeader(response.Code)
+		b, _ := json.MarshalIndent(response, "", "	")
+		fmt.Fprintf(w, string(b))
+		return
+	}
+	b, _ := json.MarshalIndent(conn.Config().Redacted(), "", "	")
+	fmt.Fprintf(w, string(b))
+}
 
 // MiddlewareHandler a http response and request wrapper
 func MiddlewareHandler(w http.ResponseWriter, r *http.Request, conn connectors.Clients, crudl string) {
@@ -47,84 +107,345 @@ func MiddlewareHandler(w http.ResponseWriter, r *http.Request, conn connectors.C
 	var response *schema.Response
 	var payload []schema.SchemaInterface
 
+	start := time.Now()
+	defer func() {
+		metrics.ObserveRequest(crudl, response.Code, start)
+		alerts.Response(crudl, response.Code)
+	}()
+
+	// the request id is returned in the header and the response and added to the connector log lines
+	rid := requestID(r)
+	r = r.WithContext(logging.WithRequestID(r.Context(), rid))
+	log := conn.Log(r.Context(), crudl)
+	w.Header().Set(logging.REQUESTID, rid)
 	w.Header().Set(CONTENTTYPE, APPLICATIONJSON)
 	//w.WriteHeader(http.StatusInternalServerError)
 
+	// the /api/v1/object(s) routes have no resource var and default to the customer resource
+	resource := mux.Vars(r)[RESOURCE]
+	if resource == "" {
+		resource = connectors.DBSCHEMA
+	}
+	if _, ok := connectors.GetResource(resource); !ok {
+		log.Error(fmt.Sprintf("MW call %s resource %s not registered", crudl, resource))
+		response = &schema.Response{Code: http.StatusNotFound, StatusCode: strconv.Itoa(http.StatusNotFound), Status: "KO", Message: fmt.Sprintf("MW call %s resource %s not found\n", crudl, resource), Payload: payload, RequestID: rid}
+		w.WriteHeader(response.Code)
+		b, _ := json.MarshalIndent(response, "", "	")
+		fmt.Fprintf(w, string(b))
+		return
+	}
+
+	// the roles of the caller must allow the operation on the resource
+	claims, _ := auth.FromContext(r.Context())
+	forbidden := auth.Authorize(claims, crudl, resource)
+
 	switch {
+	case forbidden != nil:
+		response, _ = handleError(log, crudl, http.StatusOK, payload, forbidden)
 	case crudl == "DBInsert":
 		body, err := ioutil.ReadAll(r.Body)
-		_, err = handleError(conn, crudl, payload, err)
+		response, err = handleError(log, crudl, http.StatusCreated, payload, err)
 		if err == nil {
-			err = conn.DBInsert(body)
-			payload = append(payload, schema.SchemaInterface{LastUpdate: time.Now().Unix(), MetaInfo: "Database Insert"})
-			response, err = handleError(conn, crudl, payload, err)
-			if err == nil {
-				w.WriteHeader(http.StatusCreated)
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
+			if response = validateBody(log, crudl, resource, body, false); response != nil {
+				break
 			}
+			err = conn.DBInsert(r.Context(), resource, body)
+			payload = append(payload, schema.SchemaInterface{LastUpdate: time.Now().Unix(), MetaInfo: "Database Insert"})
+			response, _ = handleError(log, crudl, http.StatusCreated, payload, err)
 		}
 	case crudl == "DBUpdate":
 		body, err := ioutil.ReadAll(r.Body)
-		_, err = handleError(conn, crudl, payload, err)
+		response, err = handleError(log, crudl, http.StatusOK, payload, err)
 		if err == nil {
-			p, e := conn.DBUpdate(body)
-			p.LastUpdate = time.Now().Unix()
+			if response = validateBody(log, crudl, resource, body, true); response != nil {
+				break
+			}
+			version, e := ifMatch(r)
+			if e != nil {
+				response, _ = handleError(log, crudl, http.StatusOK, payload, e)
+				break
+			}
+			// the lastupdate of the response is the new version of the document
+			p, e := conn.DBUpdate(r.Context(), resource, body, version)
 			p.MetaInfo = "Database Update"
 			payload = append(payload, p)
-			response, err = handleError(conn, crudl, payload, e)
+			response, err = handleError(log, crudl, http.StatusOK, payload, e)
 			if err == nil {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
+				w.Header().Set(ETAG, etag(p.LastUpdate))
 			}
 		}
 	case crudl == "DBDelete":
 		vars := mux.Vars(r)
-		err := conn.DBDelete(vars[ID])
+		err := conn.DBDelete(r.Context(), resource, vars[ID])
 		payload = append(payload, schema.SchemaInterface{LastUpdate: time.Now().Unix(), MetaInfo: "Database Delete"})
-		response, err = handleError(conn, crudl, payload, err)
-		if err == nil {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		response, _ = handleError(log, crudl, http.StatusOK, payload, err)
+	case crudl == "DBRestore":
+		vars := mux.Vars(r)
+		err := conn.DBRestore(r.Context(), resource, vars[ID])
+		payload = append(payload, schema.SchemaInterface{LastUpdate: time.Now().Unix(), MetaInfo: "Database Restore"})
+		response, _ = handleError(log, crudl, http.StatusOK, payload, err)
 	case crudl == "DBGet":
 		vars := mux.Vars(r)
-		p, err := conn.DBGet(vars[ID])
+		p, err := conn.DBGet(r.Context(), resource, vars[ID])
 		payload = append(payload, p)
-		response, err = handleError(conn, crudl, payload, err)
+		response, err = handleError(log, crudl, http.StatusOK, payload, err)
 		if err == nil {
-			w.WriteHeader(http.StatusOK)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.Header().Set(ETAG, etag(p.LastUpdate))
 		}
 	case crudl == "DBList":
+		var total int
+		var p []schema.SchemaInterface
+		lr, err := listRange(r)
+		if err == nil {
+			p, err = conn.DBList(r.Context(), resource, lr)
+		}
+		if err == nil {
+			total, err = conn.DBCount(r.Context(), resource, lr)
+		}
+		response, err = handleError(log, crudl, http.StatusOK, p, err)
+		if err == nil {
+			response.Total = total
+			response.Next = nextCursor(lr, p)
+		}
+	case crudl == "DBHistory":
+		var history []schema.AuditEntry
 		vars := mux.Vars(r)
-		from, _ := strconv.Atoi(vars[FROM])
-		to, _ := strconv.Atoi(vars[TO])
-		lr := &schema.ListRange{From: from, To: to, Search: vars[SEARCH]}
-		p, err := conn.DBList(lr)
-		response, err = handleError(conn, crudl, p, err)
+		lr, err := listRange(r)
+		if err == nil {
+			history, err = conn.DBHistory(r.Context(), resource, vars[ID], lr)
+		}
+		response, err = handleError(log, crudl, http.StatusOK, payload, err)
 		if err == nil {
-			w.WriteHeader(http.StatusOK)
+			response.History = history
 		}
+	case crudl == "DBBulkInsert", crudl == "DBBulkUpsert", crudl == "DBBulkDelete":
+		response = handleBulk(log, conn, r, crudl, resource)
+	default:
+		response, _ = handleError(log, crudl, http.StatusOK, payload, fmt.Errorf("%w : operation %s", connectors.ErrValidation, crudl))
 	}
+	response.RequestID = rid
+	w.WriteHeader(response.Code)
 	b, _ := json.MarshalIndent(response, "", "	")
 	fmt.Fprintf(w, string(b))
 }
 
 // utility functions
 
-// handleError - private
-func handleError(conn connectors.Clients, crudl string, p []schema.SchemaInterface, err error) (*schema.Response, error) {
+// validateBody - private, checks the body against the resource json schema
+// returns a bad request response with the field level errors or nil if the body is valid
+func validateBody(log *logging.Logger, crudl string, resource string, body []byte, partial bool) *schema.Response {
+	errs := validator.ValidateDocument(resource, body, partial)
+	if len(errs) == 0 {
+		return nil
+	}
+	log.Debug(fmt.Sprintf("MW call %s validation errors %v", crudl, errs))
+	return &schema.Response{Code: http.StatusBadRequest, StatusCode: strconv.Itoa(http.StatusBadRequest), Status: "KO", Message: fmt.Sprintf("MW call %s request body not valid for %s\n", crudl, resource), Errors: errs}
+}
+
+// handleBulk - private, runs a bulk request (a json array of documents or of ids for deletes) and reports
+// the outcome of each item, if all items succeed it's a 200 (201 for inserts) otherwise a 207 multi status
+func handleBulk(log *logging.Logger, conn connectors.Clients, r *http.Request, crudl string, resource string) *schema.Response {
+	var items []json.RawMessage
+	var ids []string
+	var results []schema.BulkResult
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		if crudl == "DBBulkDelete" {
+			err = json.Unmarshal(body, &ids)
+		} else {
+			err = json.Unmarshal(body, &items)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w : bulk body must be a json array %v", connectors.ErrValidation, err)
+		}
+	}
+	if err == nil && len(items)+len(ids) > BULKLIMIT {
+		err = fmt.Errorf("%w : bulk requests are limited to %d items", connectors.ErrValidation, BULKLIMIT)
+	}
 	if err != nil {
-		conn.Error("MW call  %v "+crudl+"\n", err)
-		response := &schema.Response{StatusCode: "500", Status: "KO", Message: fmt.Sprintf("MW call %s %v\n", crudl, err), Payload: p}
+		response, _ := handleError(log, crudl, http.StatusOK, nil, err)
+		return response
+	}
+
+	code := http.StatusOK
+	switch crudl {
+	case "DBBulkInsert":
+		code = http.StatusCreated
+		results, err = bulkDocuments(r.Context(), conn, resource, items, conn.DBBulkInsert)
+	case "DBBulkUpsert":
+		// the upserted documents replace the stored ones, they must be complete
+		results, err = bulkDocuments(r.Context(), conn, resource, items, conn.DBBulkUpsert)
+	case "DBBulkDelete":
+		results, err = conn.DBBulkDelete(r.Context(), resource, ids)
+	}
+	if err != nil {
+		response, _ := handleError(log, crudl, code, nil, err)
+		return response
+	}
+
+	failed := 0
+	for x := range results {
+		if results[x].Err != nil {
+			results[x].Code = statusCode(results[x].Err)
+			results[x].Message = results[x].Err.Error()
+		}
+		if results[x].Code == 0 {
+			results[x].Code = code
+			results[x].Status = "OK"
+		} else {
+			results[x].Status = "KO"
+			failed++
+		}
+	}
+	response, _ := handleError(log, crudl, code, nil, nil)
+	response.Results = results
+	if failed > 0 {
+		log.Error(fmt.Sprintf("MW call %s %d of %d items failed", crudl, failed, len(results)))
+		response.Code = http.StatusMultiStatus
+		response.StatusCode = strconv.Itoa(http.StatusMultiStatus)
+		response.Status = "KO"
+		response.Message = fmt.Sprintf("MW call %s %d of %d items failed\n", crudl, failed, len(results))
+	}
+	return response
+}
+
+// bulkDocuments - private, validates each document against the resource json schema and sends the valid ones
+// to the bulk operation, the results of the invalid documents are a 400 with the field level errors
+func bulkDocuments(ctx context.Context, conn connectors.Clients, resource string, items []json.RawMessage, bulk func(context.Context, string, []json.RawMessage) ([]schema.BulkResult, error)) ([]schema.BulkResult, error) {
+	var valid []int
+	var docs []json.RawMessage
+
+	results := make([]schema.BulkResult, len(items))
+	for x, item := range items {
+		results[x].Index = x
+		if errs := validator.ValidateDocument(resource, item, false); len(errs) > 0 {
+			results[x].Code = http.StatusBadRequest
+			results[x].Message = fmt.Sprintf("document not valid %v", errs)
+			continue
+		}
+		valid = append(valid, x)
+		docs = append(docs, item)
+	}
+	if len(docs) == 0 {
+		return results, nil
+	}
+	res, err := bulk(ctx, resource, docs)
+	if err != nil {
+		return nil, err
+	}
+	for x, index := range valid {
+		res[x].Index = index
+		results[index] = res[x]
+	}
+	return results, nil
+}
+
+// requestID - private, the request id set by a previous middleware, otherwise the X-Request-ID
+// of the caller if it's valid or a new one
+func requestID(r *http.Request) string {
+	if id := logging.RequestID(r.Context()); id != "" {
+		return id
+	}
+	id := r.Header.Get(logging.REQUESTID)
+	if !logging.ValidRequestID(id) {
+		id = logging.NewRequestID()
+	}
+	return id
+}
+
+// etag - private, the document version (lastupdate) as a strong entity tag
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch - private, parses the If-Match header into the expected document version
+// returns 0 if the header is not set or is "*" (i.e. any version)
+func ifMatch(r *http.Request) (int64, error) {
+	val := strings.TrimPrefix(strings.TrimSpace(r.Header.Get(IFMATCH)), "W/")
+	if val == "" || val == "*" {
+		return 0, nil
+	}
+	version, err := strconv.ParseInt(strings.Trim(val, "\""), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w : If-Match %s is not a document version", connectors.ErrValidation, val)
+	}
+	return version, nil
+}
+
+// listRange - private, builds the list range from the route vars (from, to, search)
+// or the query params (cursor, limit, search, email, includeDeleted) used for keyset pagination
+// returns an error if from is negative
+func listRange(r *http.Request) (*schema.ListRange, error) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	from, _ := strconv.Atoi(vars[FROM])
+	to, _ := strconv.Atoi(vars[TO])
+	if from < 0 {
+		return nil, fmt.Errorf("%w : list range from %d is negative", connectors.ErrValidation, from)
+	}
+	search := vars[SEARCH]
+	if query.Get(LIMIT) != "" {
+		limit, _ := strconv.Atoi(query.Get(LIMIT))
+		from, to = 0, limit
+	}
+	if query.Get(SEARCH) != "" {
+		search = query.Get(SEARCH)
+	}
+	// never return an unbounded list
+	if from == 0 && to <= 0 {
+		to = PAGESIZE
+	}
+	if to-from > MAXPAGESIZE {
+		to = from + MAXPAGESIZE
+	}
+	deleted, _ := strconv.ParseBool(query.Get(INCLUDEDELETED))
+	return &schema.ListRange{From: from, To: to, Search: search, Cursor: query.Get(CURSOR), Email: query.Get(EMAIL), IncludeDeleted: deleted}, nil
+}
+
+// nextCursor - private, the cursor for the following page (empty when this is the last page)
+func nextCursor(lr *schema.ListRange, p []schema.SchemaInterface) string {
+	limit := lr.To - lr.From
+	if limit <= 0 || len(p) < limit {
+		return ""
+	}
+	return p[len(p)-1].ID.Hex()
+}
+
+// handleError - private, builds the response for the crudl call and logs its outcome (with the request log fields)
+// code is the status on success, errors are mapped to a status with statusCode
+func handleError(log *logging.Logger, crudl string, code int, p []schema.SchemaInterface, err error) (*schema.Response, error) {
+	if err != nil {
+		code = statusCode(err)
+		log.Error(fmt.Sprintf("MW call %s %v", crudl, err))
+		response := &schema.Response{Code: code, StatusCode: strconv.Itoa(code), Status: "KO", Message: fmt.Sprintf("MW call %s %v\n", crudl, err), Payload: p}
 		return response, err
 	}
-	conn.Info("MW call  %s succesfull\n", crudl)
-	conn.Trace("MW call  %s details %v\n", crudl, p)
-	response := &schema.Response{StatusCode: "200", Status: "OK", Message: fmt.Sprintf("MW call  %s successfull \n", crudl), Payload: p}
+	log.Info(fmt.Sprintf("MW call %s succesfull", crudl))
+	log.Trace(fmt.Sprintf("MW call %s details %v", crudl, p))
+	response := &schema.Response{Code: code, StatusCode: strconv.Itoa(code), Status: "OK", Message: fmt.Sprintf("MW call  %s successfull \n", crudl), Payload: p}
 	return response, nil
 }
+
+// statusCode - private, maps the connectors error kinds to a http status
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return http.StatusUnauthorized
+	case errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, connectors.ErrInvalidID):
+		return http.StatusBadRequest
+	case errors.Is(err, connectors.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, connectors.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, connectors.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, connectors.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, connectors.ErrTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
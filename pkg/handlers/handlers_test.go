@@ -2,21 +2,29 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	//"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/auth"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/config"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/connectors"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/logging"
 	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
-	"github.com/globalsign/mgo/bson"
-	"github.com/microlib/simple"
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/validator"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
@@ -30,14 +38,20 @@ const (
 	DBSESSION string = "Failed to clone session"
 	OK        string = "OK"
 	DATABASE  string = ""
+	NOTFOUND  string = "5cc042307ccc69ada8930000"
 )
 
+// fakeID - the id of the fake documents
+var fakeID, _ = primitive.ObjectIDFromHex("5cc042307ccc69ada893144c")
+
 type FakeConnections struct {
+	Down  bool
 	Http  *http.Client
 	Redis *MemoryCache
-	l     *simple.Logger
+	l     *logging.Logger
 	DB    SessionInterface
 	Name  string
+	cfg   *config.Config
 }
 
 // MemoryCache
@@ -195,7 +209,7 @@ func (fq FakeQuery) All(result interface{}) error {
 // One fake.
 func (fq FakeQuery) One(result interface{}) error {
 	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test.com"}
-	*result.(*schema.SchemaInterface) = schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
+	*result.(*schema.SchemaInterface) = schema.SchemaInterface{ID: fakeID, LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
 	return nil
 }
 
@@ -233,7 +247,7 @@ func (fq FakeQuery) Skip(val int) Query {
 
 func (fi FakeIter) Next(data interface{}) bool {
 	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test.com"}
-	*data.(*schema.SchemaInterface) = schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
+	*data.(*schema.SchemaInterface) = schema.SchemaInterface{ID: fakeID, LastUpdate: 123434, MetaInfo: "Fake data", Custom: custom}
 	return false
 }
 
@@ -244,7 +258,7 @@ func (fi FakeIter) Err() error {
 func (fi FakeIter) Close() {
 }
 
-func NewClientTestConnections(file string, status int, logger *simple.Logger) connectors.Clients {
+func NewClientTestConnections(file string, status int, logger *logging.Logger) connectors.Clients {
 
 	// we first load the json payload to simulate a call to middleware
 	// for now just ignore failures.
@@ -266,7 +280,7 @@ func NewClientTestConnections(file string, status int, logger *simple.Logger) co
 	redisClient := &MemoryCache{m: make(map[string]string)}
 	mgo := NewFakeSession()
 
-	return &FakeConnections{Http: httpClient, Redis: redisClient, DB: mgo, Name: "FakeConnections", l: logger}
+	return &FakeConnections{Http: httpClient, Redis: redisClient, DB: mgo, Name: "FakeConnections", l: logger, cfg: config.Default()}
 }
 
 func assertEqual(t *testing.T, a interface{}, b interface{}) {
@@ -277,8 +291,16 @@ func assertEqual(t *testing.T, a interface{}, b interface{}) {
 
 // implementation
 
+func (r *FakeConnections) Config() *config.Config {
+	return r.cfg
+}
+
+func (r *FakeConnections) SetConfig(c *config.Config) {
+	r.cfg = c
+}
+
 // fake redis Get
-func (r *FakeConnections) Get(key string) (string, error) {
+func (r *FakeConnections) Get(ctx context.Context, key string) (string, error) {
 	r.Redis.lck.RLock()
 	defer r.Redis.lck.RUnlock()
 	val, ok := r.Redis.m[key]
@@ -289,7 +311,7 @@ func (r *FakeConnections) Get(key string) (string, error) {
 }
 
 // fake redis Set
-func (r *FakeConnections) Set(key string, value string, expr time.Duration) (string, error) {
+func (r *FakeConnections) Set(ctx context.Context, key string, value string, expr time.Duration) (string, error) {
 	r.Redis.lck.Lock()
 	defer r.Redis.lck.Unlock()
 	r.Redis.m[key] = value
@@ -297,7 +319,7 @@ func (r *FakeConnections) Set(key string, value string, expr time.Duration) (str
 }
 
 // fake redis Close
-func (r *FakeConnections) Del(key string) error {
+func (r *FakeConnections) Del(ctx context.Context, key string) error {
 	r.Redis.lck.Lock()
 	defer r.Redis.lck.Unlock()
 	delete(r.Redis.m, key)
@@ -313,38 +335,127 @@ func (r *FakeConnections) Do(req *http.Request) (*http.Response, error) {
 	return r.Http.Do(req)
 }
 
-func (r *FakeConnections) DBInsert(body []byte) error {
+func (r *FakeConnections) DBInsert(ctx context.Context, resource string, body []byte) error {
 	return nil
 }
 
-func (r *FakeConnections) DBGet(id string) (schema.SchemaInterface, error) {
+func (r *FakeConnections) DBGet(ctx context.Context, resource string, id string) (schema.SchemaInterface, error) {
+	if id == NOTFOUND {
+		return schema.SchemaInterface{}, fmt.Errorf("%w : %s", connectors.ErrNotFound, id)
+	}
+	if ctx.Err() != nil {
+		return schema.SchemaInterface{}, fmt.Errorf("%w : %v", connectors.ErrTimeout, ctx.Err())
+	}
 	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
-	d := schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 1323434, MetaInfo: "nada", Custom: custom}
+	d := schema.SchemaInterface{ID: fakeID, LastUpdate: 1323434, MetaInfo: "nada", Custom: custom}
 	return d, nil
 }
 
-func (r *FakeConnections) DBUpdate(body []byte) (schema.SchemaInterface, error) {
+func (r *FakeConnections) DBUpdate(ctx context.Context, resource string, body []byte, version int64) (schema.SchemaInterface, error) {
+	if version != 0 && version != 1323434 {
+		return schema.SchemaInterface{}, fmt.Errorf("%w : version %d", connectors.ErrConflict, version)
+	}
 	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
-	d := schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 1323434, MetaInfo: "nada", Custom: custom}
+	d := schema.SchemaInterface{ID: fakeID, LastUpdate: 1323434, MetaInfo: "nada", Custom: custom}
 	return d, nil
 }
 
-func (r *FakeConnections) DBDelete(id string) error {
+func (r *FakeConnections) DBDelete(ctx context.Context, resource string, id string) error {
+	return nil
+}
+
+func (r *FakeConnections) DBRestore(ctx context.Context, resource string, id string) error {
+	if id == "nada" {
+		return fmt.Errorf("%w : %s is not deleted", connectors.ErrNotFound, id)
+	}
+	return nil
+}
+
+func (r *FakeConnections) DBPurge(ctx context.Context, resource string, before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (r *FakeConnections) DBDelivery(ctx context.Context, d schema.Delivery) error {
 	return nil
 }
 
-func (r *FakeConnections) DBList(lr *schema.ListRange) ([]schema.SchemaInterface, error) {
+func (r *FakeConnections) DBHistory(ctx context.Context, resource string, id string, lr *schema.ListRange) ([]schema.AuditEntry, error) {
+	if id == "nada" {
+		return nil, connectors.ErrInvalidID
+	}
+	changes := []schema.Change{{Field: "custom.email", Before: "test@test", After: "test@test.com"}}
+	return []schema.AuditEntry{{Resource: resource, DocumentID: id, Operation: "DBUpdate", Actor: "test", Changes: changes}}, nil
+}
+
+func (r *FakeConnections) DBList(ctx context.Context, resource string, lr *schema.ListRange) ([]schema.SchemaInterface, error) {
 	var p []schema.SchemaInterface
+	if lr.Cursor == "nada" {
+		return p, connectors.ErrInvalidID
+	}
 	custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "test@test"}
-	d := schema.SchemaInterface{ID: bson.ObjectIdHex("5cc042307ccc69ada893144c"), LastUpdate: 1323434, MetaInfo: "nada", Custom: custom}
+	d := schema.SchemaInterface{ID: fakeID, LastUpdate: 1323434, MetaInfo: "nada", Custom: custom}
 	p = append(p, d)
 	return p, nil
 }
 
+func (r *FakeConnections) DBCount(ctx context.Context, resource string, lr *schema.ListRange) (int, error) {
+	return 1, nil
+}
+
+func (r *FakeConnections) DBBulkInsert(ctx context.Context, resource string, items []json.RawMessage) ([]schema.BulkResult, error) {
+	return r.fakeBulk(items)
+}
+
+func (r *FakeConnections) DBBulkUpsert(ctx context.Context, resource string, items []json.RawMessage) ([]schema.BulkResult, error) {
+	return r.fakeBulk(items)
+}
+
+func (r *FakeConnections) DBBulkDelete(ctx context.Context, resource string, ids []string) ([]schema.BulkResult, error) {
+	res := make([]schema.BulkResult, len(ids))
+	for x, id := range ids {
+		res[x] = schema.BulkResult{Index: x, ID: id}
+		if !primitive.IsValidObjectID(id) {
+			res[x].Err = connectors.ErrInvalidID
+		}
+	}
+	return res, nil
+}
+
+// fakeBulk fails the items with the metainfo "ERROR"
+func (r *FakeConnections) fakeBulk(items []json.RawMessage) ([]schema.BulkResult, error) {
+	res := make([]schema.BulkResult, len(items))
+	for x, item := range items {
+		var d schema.SchemaInterface
+		json.Unmarshal(item, &d)
+		res[x] = schema.BulkResult{Index: x, ID: "5cc042307ccc69ada893144c"}
+		if d.MetaInfo == "ERROR" {
+			res[x].Err = fmt.Errorf("%w : forced error", connectors.ErrConflict)
+		}
+	}
+	return res, nil
+}
+
+// fake health, Down reports mongodb as down
+func (r *FakeConnections) Health(ctx context.Context) []schema.Dependency {
+	mongodb := schema.Dependency{Name: "mongodb", Status: connectors.UP, LatencyMs: 0.5}
+	if r.Down {
+		mongodb = schema.Dependency{Name: "mongodb", Status: connectors.DOWN, LatencyMs: 0.5, Message: "no reachable servers"}
+	}
+	return []schema.Dependency{mongodb, {Name: "redis", Status: connectors.UP, LatencyMs: 0.1}}
+}
+
 func (r *FakeConnections) Error(msg string, val ...interface{}) {
 	r.l.Error(fmt.Sprintf(msg, val...))
 }
 
+func (r *FakeConnections) Log(ctx context.Context, operation string) *logging.Logger {
+	fields := []interface{}{"operation", operation}
+	if rid := logging.RequestID(ctx); rid != "" {
+		fields = append(fields, "requestid", rid)
+	}
+	return r.l.With(fields...)
+}
+
 func (r *FakeConnections) Info(msg string, val ...interface{}) {
 	r.l.Info(fmt.Sprintf(msg, val...))
 }
@@ -359,15 +470,18 @@ func (r *FakeConnections) Trace(msg string, val ...interface{}) {
 
 func TestHandlers(t *testing.T) {
 
-	logger := &simple.Logger{Level: "info"}
+	logger := logging.New(os.Stdout, "info")
 
 	t.Run("IsAlive : should pass", func(t *testing.T) {
 		var STATUS int = 200
 		// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 		rr := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/v2/sys/info/isalive", nil)
-		NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
-		handler := http.HandlerFunc(IsAlive)
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+		conn.Config().Version = "1.0.3"
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			IsAlive(w, r, conn)
+		})
 		handler.ServeHTTP(rr, req)
 
 		body, e := ioutil.ReadAll(rr.Body)
@@ -379,6 +493,38 @@ func TestHandlers(t *testing.T) {
 		if rr.Code != STATUS {
 			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "IsAlive", rr.Code, STATUS))
 		}
+		if !strings.Contains(string(body), "1.0.3") {
+			t.Errorf(fmt.Sprintf("Handler %s returned with no version - got (%s) wanted (%s)", "IsAlive", string(body), "1.0.3"))
+		}
+	})
+
+	t.Run("IsReady : should report each dependency", func(t *testing.T) {
+		tests := []struct {
+			conn   connectors.Clients
+			status int
+			ready  bool
+		}{
+			{NewClientTestConnections("../../tests/payload-example.json", 200, logger), 200, true},
+			{&FakeConnections{Down: true, l: logger, cfg: config.Default()}, 503, false},
+			{nil, 503, false},
+		}
+		for _, tt := range tests {
+			var response schema.Readiness
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v2/sys/info/isready", nil)
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				IsReady(w, r, tt.conn)
+			})
+			handler.ServeHTTP(rr, req)
+			body, _ := ioutil.ReadAll(rr.Body)
+			logger.Trace(fmt.Sprintf("Response %s", string(body)))
+			if rr.Code != tt.status {
+				t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "IsReady", rr.Code, tt.status))
+			}
+			json.Unmarshal(body, &response)
+			assertEqual(t, response.Ready, tt.ready)
+			assertEqual(t, len(response.Dependencies), 2)
+		}
 	})
 
 	t.Run("DBInsert : should pass", func(t *testing.T) {
@@ -447,6 +593,175 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("MiddlewareHandler : should propagate or generate the request id", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+		for _, tc := range []struct{ header, want string }{
+			{"abc-123", "abc-123"},
+			{"", ""},
+			{"not valid", ""},
+		} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/object", nil)
+			req.Header.Set(logging.REQUESTID, tc.header)
+			handler.ServeHTTP(rr, req)
+			var response schema.Response
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			rid := rr.Header().Get(logging.REQUESTID)
+			if rid == "" || rid != response.RequestID || (tc.want != "" && rid != tc.want) || (tc.want == "" && rid == tc.header) {
+				t.Errorf(fmt.Sprintf("Handler %s request id %q returned - got (%s, %s) wanted (%s)", "DBGet", tc.header, rid, response.RequestID, tc.want))
+			}
+		}
+	})
+
+	t.Run("MiddlewareHandler : the error line should carry the request id and operation", func(t *testing.T) {
+		var buf bytes.Buffer
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logging.New(&buf, "info"))
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/nada/5cc042307ccc69ada893144c", nil)
+		req = mux.SetURLVars(req, map[string]string{RESOURCE: "nada", ID: "5cc042307ccc69ada893144c"})
+		req.Header.Set(logging.REQUESTID, "abc-123")
+		handler.ServeHTTP(rr, req)
+		var line map[string]interface{}
+		for _, l := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(l, "MW call DBGet") {
+				json.Unmarshal([]byte(l), &line)
+			}
+		}
+		if line["requestid"] != "abc-123" || line["operation"] != "DBGet" || line["level"] != "ERROR" {
+			t.Errorf(fmt.Sprintf("Handler %s log line - got (%v) wanted (%s)", "DBGet", buf.String(), "abc-123"))
+		}
+	})
+
+	t.Run("Authenticate : should reject requests without a valid token", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		v, _ := auth.NewVerifier("a-shared-secret", "", "", "")
+		var claims auth.Claims
+		handler := Authenticate(v, conn)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ = auth.FromContext(r.Context())
+			MiddlewareHandler(w, r, conn, "DBGet")
+		}))
+		token := func(secret string) string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(time.Minute).Unix()}).SignedString([]byte(secret))
+			return s
+		}
+		for _, tc := range []struct {
+			header string
+			code   int
+		}{
+			{"Bearer " + token("a-shared-secret"), http.StatusOK},
+			{"Bearer " + token("nada"), http.StatusUnauthorized},
+			{"", http.StatusUnauthorized},
+		} {
+			claims = nil
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/object", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "5cc042307ccc69ada893144c"})
+			req.Header.Set(AUTHORIZATION, tc.header)
+			handler.ServeHTTP(rr, req)
+			var response schema.Response
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			if rr.Code != tc.code || response.Code != tc.code || response.RequestID != rr.Header().Get(logging.REQUESTID) {
+				t.Errorf(fmt.Sprintf("Handler %s returned - got (%d %d) wanted (%d)", "Authenticate", rr.Code, response.Code, tc.code))
+			}
+			if tc.code == http.StatusOK && claims.Subject() != "user-1" {
+				t.Errorf(fmt.Sprintf("Handler %s claims - got (%v) wanted (%s)", "Authenticate", claims, "user-1"))
+			}
+			if tc.code == http.StatusUnauthorized && rr.Header().Get(WWWAUTHENTICATE) == "" {
+				t.Errorf(fmt.Sprintf("Handler %s returned no %s header", "Authenticate", WWWAUTHENTICATE))
+			}
+		}
+	})
+
+	t.Run("MiddlewareHandler : should enforce the access policy", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		if err := auth.LoadPolicy("../../tests/policy.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Handler %s returned with error - got (%v) wanted (%s)", "LoadPolicy", err, "nil"))
+		}
+		defer auth.SetPolicy(nil)
+		handler := Authenticate(nil, conn)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, mux.Vars(r)["crudl"])
+		}))
+		for _, tc := range []struct {
+			key   string
+			crudl string
+			code  int
+		}{
+			{"test-api-key", "DBGet", http.StatusOK},
+			{"test-api-key", "DBDelete", http.StatusForbidden},
+			{"nada", "DBGet", http.StatusUnauthorized},
+			{"", "DBGet", http.StatusUnauthorized},
+		} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/object", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "5cc042307ccc69ada893144c", "crudl": tc.crudl})
+			req.Header.Set(APIKEY, tc.key)
+			handler.ServeHTTP(rr, req)
+			var response schema.Response
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			if rr.Code != tc.code || response.Code != tc.code {
+				t.Errorf(fmt.Sprintf("Handler %s %s returned - got (%d) wanted (%d)", tc.crudl, tc.key, rr.Code, tc.code))
+			}
+			if tc.code == http.StatusForbidden && !strings.Contains(response.Message, "DBDelete on customer is not allowed for batch-import") {
+				t.Errorf(fmt.Sprintf("Handler %s message - got (%s)", tc.crudl, response.Message))
+			}
+		}
+	})
+
+	t.Run("EffectiveConfig : should redact the secrets", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		conn.Config().MongoDB.Password = "mongo-pwd"
+		conn.Config().Auth.Secret = "jwt-secret"
+		conn.Config().Cache.TTL = 60
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v2/sys/config", nil)
+		EffectiveConfig(rr, req, conn)
+		var cfg config.Config
+		json.Unmarshal(rr.Body.Bytes(), &cfg)
+		assertEqual(t, rr.Code, http.StatusOK)
+		assertEqual(t, cfg.Cache.TTL, 60)
+		assertEqual(t, cfg.MongoDB.Password, config.REDACTED)
+		if strings.Contains(rr.Body.String(), "jwt-secret") {
+			t.Errorf(fmt.Sprintf("Handler %s returned a secret - got (%s)", "EffectiveConfig", rr.Body.String()))
+		}
+	})
+
+	t.Run("EffectiveConfig : should enforce the access policy", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		if err := auth.LoadPolicy("../../tests/policy.json"); err != nil {
+			t.Fatalf(fmt.Sprintf("Handler %s returned with error - got (%v) wanted (%s)", "LoadPolicy", err, "nil"))
+		}
+		defer auth.SetPolicy(nil)
+		handler := Authenticate(nil, conn)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			EffectiveConfig(w, r, conn)
+		}))
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v2/sys/config", nil)
+		req.Header.Set(APIKEY, "test-api-key")
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf(fmt.Sprintf("Handler %s returned - got (%d) wanted (%d)", "EffectiveConfig", rr.Code, http.StatusForbidden))
+		}
+	})
+
+	t.Run("Authenticate : a nil verifier should not authenticate", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		handler := Authenticate(nil, conn)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBList")
+		}))
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/objects", nil)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf(fmt.Sprintf("Handler %s returned - got (%d) wanted (%d)", "Authenticate", rr.Code, http.StatusOK))
+		}
+	})
+
 	t.Run("DBDelete : should pass", func(t *testing.T) {
 		var STATUS int = 200
 		// insert a good peices of data
@@ -469,6 +784,38 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("DBRestore : should pass", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		for id, status := range map[string]int{"5cc042307ccc69ada893144c": http.StatusOK, "nada": http.StatusNotFound} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/object/"+id+"/restore", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": id})
+			MiddlewareHandler(rr, req, conn, "DBRestore")
+			if rr.Code != status {
+				t.Errorf(fmt.Sprintf("Handler %s %s returned - got (%d) wanted (%d)", "DBRestore", id, rr.Code, status))
+			}
+		}
+	})
+
+	t.Run("DBHistory : should return the audit entries", func(t *testing.T) {
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+		for id, status := range map[string]int{"5cc042307ccc69ada893144c": http.StatusOK, "nada": http.StatusBadRequest} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/api/v1/object/"+id+"/history", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": id})
+			MiddlewareHandler(rr, req, conn, "DBHistory")
+			if rr.Code != status {
+				t.Errorf(fmt.Sprintf("Handler %s %s returned - got (%d) wanted (%d)", "DBHistory", id, rr.Code, status))
+			}
+			var response schema.Response
+			json.Unmarshal(rr.Body.Bytes(), &response)
+			if status == http.StatusOK {
+				assertEqual(t, len(response.History), 1)
+				assertEqual(t, response.History[0].Actor, "test")
+			}
+		}
+	})
+
 	t.Run("DBList : should pass", func(t *testing.T) {
 		var STATUS int = 200
 		// insert a good peices of data
@@ -488,6 +835,386 @@ func TestHandlers(t *testing.T) {
 		}
 	})
 
+	t.Run("DBList : cursor pagination should pass", func(t *testing.T) {
+		var STATUS int = 200
+		var response schema.Response
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/objects?limit=1&cursor=5cc042307ccc69ada8931440", nil)
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBList")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with no error - got (%d) wanted (%d)", "DBList", rr.Code, STATUS))
+		}
+		json.Unmarshal(body, &response)
+		assertEqual(t, response.Total, 1)
+		assertEqual(t, response.Next, "5cc042307ccc69ada893144c")
+	})
+
+	t.Run("DBInsert : invalid body should fail", func(t *testing.T) {
+		var STATUS int = 400
+		var response schema.Response
+		validator.RegisterSchema("validated", "../../schemas/customer.json")
+		connectors.RegisterResource(schema.Resource{Name: "validated"})
+		custom := schema.CustomDetail{Name: "test", Surname: "test", Email: "", Mobile: "garbage"}
+		d := schema.SchemaInterface{MetaInfo: "nada", Custom: custom}
+		b, _ := json.Marshal(d)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/validated", bytes.NewBuffer(b))
+		req = mux.SetURLVars(req, map[string]string{RESOURCE: "validated"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBInsert")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBInsert", rr.Code, STATUS))
+		}
+		json.Unmarshal(body, &response)
+		assertEqual(t, len(response.Errors), 2)
+	})
+
+	t.Run("DBUpdate : partial body should pass", func(t *testing.T) {
+		var STATUS int = 200
+		b := []byte(`{"_id":"5cc042307ccc69ada893144c","custom":{"email":"test@test.com"}}`)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/v1/validated", bytes.NewBuffer(b))
+		req = mux.SetURLVars(req, map[string]string{RESOURCE: "validated"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBUpdate")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBUpdate", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("DBGet : not found should fail", func(t *testing.T) {
+		var STATUS int = 404
+		var response schema.Response
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/object/"+NOTFOUND, nil)
+		req = mux.SetURLVars(req, map[string]string{ID: NOTFOUND})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBGet", rr.Code, STATUS))
+		}
+		json.Unmarshal(body, &response)
+		assertEqual(t, response.Code, STATUS)
+		assertEqual(t, response.StatusCode, "404")
+	})
+
+	t.Run("DBGet : expired request context should time out", func(t *testing.T) {
+		var STATUS int = 504
+		rr := httptest.NewRecorder()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, _ := http.NewRequest("GET", "/api/v1/object/5cc042307ccc69ada893144c", nil)
+		req = mux.SetURLVars(req.WithContext(ctx), map[string]string{ID: "5cc042307ccc69ada893144c"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+
+		handler.ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBGet", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("DBList : negative from should fail", func(t *testing.T) {
+		var STATUS int = 422
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/objects/-5/10", nil)
+		req = mux.SetURLVars(req, map[string]string{FROM: "-5", TO: "10"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBList")
+		})
+
+		handler.ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBList", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("DBList : invalid cursor should fail", func(t *testing.T) {
+		var STATUS int = 400
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/objects?cursor=nada", nil)
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBList")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBList", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("statusCode : should map the error kinds", func(t *testing.T) {
+		tests := []struct {
+			err  error
+			code int
+		}{
+			{connectors.ErrInvalidID, 400},
+			{fmt.Errorf("%w : test", connectors.ErrNotFound), 404},
+			{connectors.ErrConflict, 409},
+			{connectors.ErrValidation, 422},
+			{connectors.ErrUnavailable, 503},
+			{connectors.ErrTimeout, 504},
+			{fmt.Errorf("%w : test", auth.ErrUnauthenticated), 401},
+			{fmt.Errorf("%w : test", auth.ErrForbidden), 403},
+			{errors.New("other"), 500},
+		}
+		for _, tt := range tests {
+			assertEqual(t, statusCode(tt.err), tt.code)
+		}
+	})
+
+	t.Run("DBGet : should set the etag", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/object/5cc042307ccc69ada893144c", nil)
+		conn := NewClientTestConnections("../../tests/payload-example.json", 200, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+
+		handler.ServeHTTP(rr, req)
+		assertEqual(t, rr.Header().Get(ETAG), `"1323434"`)
+	})
+
+	t.Run("DBUpdate : If-Match should be checked", func(t *testing.T) {
+		tests := []struct {
+			ifmatch string
+			status  int
+		}{
+			{`"1323434"`, 200},
+			{`W/"1323434"`, 200},
+			{"*", 200},
+			{`"1"`, 409},
+			{"nada", 422},
+		}
+		for _, tt := range tests {
+			b := []byte(`{"_id":"5cc042307ccc69ada893144c","metainfo":"nada"}`)
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/api/v1/object", bytes.NewBuffer(b))
+			req.Header.Set(IFMATCH, tt.ifmatch)
+			conn := NewClientTestConnections("../../tests/payload-example.json", tt.status, logger)
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				MiddlewareHandler(w, r, conn, "DBUpdate")
+			})
+
+			handler.ServeHTTP(rr, req)
+			body, _ := ioutil.ReadAll(rr.Body)
+			logger.Info(fmt.Sprintf("Response %s", string(body)))
+			if rr.Code != tt.status {
+				t.Errorf(fmt.Sprintf("Handler %s If-Match %s returned with incorrect status code - got (%d) wanted (%d)", "DBUpdate", tt.ifmatch, rr.Code, tt.status))
+			}
+			if tt.status == 200 {
+				assertEqual(t, rr.Header().Get(ETAG), `"1323434"`)
+			}
+		}
+	})
+
+	t.Run("DBBulkInsert : should pass", func(t *testing.T) {
+		var STATUS int = 201
+		var response schema.Response
+		b := []byte(`[{"metainfo":"test"},{"metainfo":"test"}]`)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/objects/bulk", bytes.NewBuffer(b))
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBBulkInsert")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBBulkInsert", rr.Code, STATUS))
+		}
+		json.Unmarshal(body, &response)
+		assertEqual(t, len(response.Results), 2)
+		assertEqual(t, response.Results[1].Code, STATUS)
+	})
+
+	t.Run("DBBulkUpsert : partial failure should be a multi status", func(t *testing.T) {
+		var STATUS int = 207
+		var response schema.Response
+		validator.RegisterSchema("bulk", "../../schemas/customer.json")
+		connectors.RegisterResource(schema.Resource{Name: "bulk"})
+		// the third item would wipe the name and surname of the stored document
+		b := []byte(`[{"metainfo":"test","custom":{"name":"John","surname":"Doe","email":"john@test.com"}},` +
+			`{"metainfo":"ERROR","custom":{"name":"John","surname":"Doe","email":"john@test.com"}},` +
+			`{"_id":"5cc042307ccc69ada893144c","custom":{"email":"x@y.z"}}]`)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/api/v1/bulk/bulk", bytes.NewBuffer(b))
+		req = mux.SetURLVars(req, map[string]string{RESOURCE: "bulk"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBBulkUpsert")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBBulkUpsert", rr.Code, STATUS))
+		}
+		json.Unmarshal(body, &response)
+		assertEqual(t, len(response.Results), 3)
+		assertEqual(t, response.Results[0].Status, "OK")
+		assertEqual(t, response.Results[1].Code, 409)
+		assertEqual(t, response.Results[2].Code, 400)
+		assertEqual(t, response.Results[2].Index, 2)
+	})
+
+	t.Run("DBBulkDelete : should report each item", func(t *testing.T) {
+		var STATUS int = 207
+		var response schema.Response
+		b := []byte(`["5cc042307ccc69ada893144c","nada"]`)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/objects/bulk/delete", bytes.NewBuffer(b))
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBBulkDelete")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBBulkDelete", rr.Code, STATUS))
+		}
+		json.Unmarshal(body, &response)
+		assertEqual(t, response.Results[1].Code, 400)
+	})
+
+	t.Run("DBBulkInsert : body not an array should fail", func(t *testing.T) {
+		var STATUS int = 422
+		b := []byte(`{"metainfo":"test"}`)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/objects/bulk", bytes.NewBuffer(b))
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBBulkInsert")
+		})
+
+		handler.ServeHTTP(rr, req)
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBBulkInsert", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("DBGet : unknown resource should fail", func(t *testing.T) {
+		var STATUS int = 404
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/nada/5cc042307ccc69ada893144c", nil)
+		req = mux.SetURLVars(req, map[string]string{RESOURCE: "nada", ID: "5cc042307ccc69ada893144c"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBGet", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("DBGet : registered resource should pass", func(t *testing.T) {
+		var STATUS int = 200
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/watchlist/5cc042307ccc69ada893144c", nil)
+		req = mux.SetURLVars(req, map[string]string{RESOURCE: "watchlist", ID: "5cc042307ccc69ada893144c"})
+		conn := NewClientTestConnections("../../tests/payload-example.json", STATUS, logger)
+		connectors.RegisterResource(schema.Resource{Name: "watchlist"})
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			MiddlewareHandler(w, r, conn, "DBGet")
+		})
+
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Body)
+		logger.Info(fmt.Sprintf("Response %s", string(body)))
+		if rr.Code != STATUS {
+			t.Errorf(fmt.Sprintf("Handler %s returned with incorrect status code - got (%d) wanted (%d)", "DBGet", rr.Code, STATUS))
+		}
+	})
+
+	t.Run("listRange : should parse route vars and query params", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/api/v1/objects?limit=50&search=test&cursor=5cc042307ccc69ada893144c&email=test@test.com", nil)
+		lr, _ := listRange(req)
+		assertEqual(t, lr.From, 0)
+		assertEqual(t, lr.To, 50)
+		assertEqual(t, lr.Search, "test")
+		assertEqual(t, lr.Cursor, "5cc042307ccc69ada893144c")
+		assertEqual(t, lr.Email, "test@test.com")
+		assertEqual(t, lr.IncludeDeleted, false)
+
+		req, _ = http.NewRequest("GET", "/api/v1/objects", nil)
+		lr, _ = listRange(req)
+		assertEqual(t, lr.To, PAGESIZE)
+
+		// the page size is capped
+		req, _ = http.NewRequest("GET", "/api/v1/objects?limit=1000000", nil)
+		lr, _ = listRange(req)
+		assertEqual(t, lr.To, MAXPAGESIZE)
+		req = mux.SetURLVars(httptest.NewRequest("GET", "/api/v1/objects/10/100000", nil), map[string]string{FROM: "10", TO: "100000"})
+		lr, _ = listRange(req)
+		assertEqual(t, lr.To-lr.From, MAXPAGESIZE)
+
+		req, _ = http.NewRequest("GET", "/api/v1/objects?includeDeleted=true", nil)
+		lr, _ = listRange(req)
+		assertEqual(t, lr.IncludeDeleted, true)
+	})
+
+	t.Run("nextCursor : last page should have no cursor", func(t *testing.T) {
+		p := []schema.SchemaInterface{{ID: fakeID}}
+		assertEqual(t, nextCursor(&schema.ListRange{From: 0, To: 2}, p), "")
+		assertEqual(t, nextCursor(&schema.ListRange{From: 0, To: 1}, p), "5cc042307ccc69ada893144c")
+		assertEqual(t, nextCursor(&schema.ListRange{From: 0, To: 1}, nil), "")
+	})
+
 	/*
 
 		func TestUpdate(t *testing.T) {
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	REQUESTID string = "X-Request-ID"
+	// the longest X-Request-ID propagated as is, longer (or empty) ids are replaced
+	MAXREQUESTID int = 128
+)
+
+// LevelTrace - below debug, used for payload dumps
+const LevelTrace slog.Level = slog.LevelDebug - 4
+
+type contextKey struct{}
+
+// Logger - writes one json object per line (time, level, msg and the key/value fields)
+// The methods match the microlib/simple logger, the fields are optional key/value pairs
+type Logger struct {
+	s     *slog.Logger
+	level *slog.LevelVar
+}
+
+// New - a json logger writing to w, level is one of error, warn, info, debug or trace (defaults to info)
+func New(w io.Writer, level string) *Logger {
+	lv := &slog.LevelVar{}
+	lv.Set(ParseLevel(level))
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lv, ReplaceAttr: replaceLevel})
+	return &Logger{s: slog.New(h), level: lv}
+}
+
+// ParseLevel - maps the LOG_LEVEL names to a slog level
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "error":
+		return slog.LevelError
+	case "warn":
+		return slog.LevelWarn
+	case "debug":
+		return slog.LevelDebug
+	case "trace":
+		return LevelTrace
+	}
+	return slog.LevelInfo
+}
+
+// replaceLevel - private, names the trace level (slog would print DEBUG-4)
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if lv, ok := a.Value.Any().(slog.Level); ok && lv == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// SetLevel - changes the level of the logger (and of the loggers derived with With)
+func (l *Logger) SetLevel(level string) {
+	l.level.Set(ParseLevel(level))
+}
+
+// Level - the current level name
+func (l *Logger) Level() string {
+	if l.level.Level() == LevelTrace {
+		return "trace"
+	}
+	return strings.ToLower(l.level.Level().String())
+}
+
+// With - a logger that adds the key/value fields to every line
+func (l *Logger) With(fields ...interface{}) *Logger {
+	return &Logger{s: l.s.With(fields...), level: l.level}
+}
+
+func (l *Logger) Error(msg string, fields ...interface{}) {
+	l.log(slog.LevelError, msg, fields)
+}
+
+func (l *Logger) Warn(msg string, fields ...interface{}) {
+	l.log(slog.LevelWarn, msg, fields)
+}
+
+func (l *Logger) Info(msg string, fields ...interface{}) {
+	l.log(slog.LevelInfo, msg, fields)
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) {
+	l.log(slog.LevelDebug, msg, fields)
+}
+
+func (l *Logger) Trace(msg string, fields ...interface{}) {
+	l.log(LevelTrace, msg, fields)
+}
+
+// log - private, messages are trimmed as most of them still end with a new line
+func (l *Logger) log(level slog.Level, msg string, fields []interface{}) {
+	l.s.Log(context.Background(), level, strings.TrimSpace(msg), fields...)
+}
+
+// WithRequestID - a ctx carrying the request id, it's added to the connector log lines
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID - the request id of the ctx (empty if not set)
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// NewRequestID - a random 128 bit id (hex)
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID - an incoming X-Request-ID is propagated if it's printable ascii and not too long
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MAXREQUESTID {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
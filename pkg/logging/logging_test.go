@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func assertEqual(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+// lines - the json objects written to the buffer
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf(fmt.Sprintf("Test log line %s is not json %v", line, err))
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func TestLogging(t *testing.T) {
+
+	t.Run("Logger : should write json lines with the fields", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := New(&buf, "info").With("operation", "DBGet")
+		l.Info("MW call DBGet succesfull\n", "durationms", 1.5)
+		out := lines(t, &buf)
+		assertEqual(t, len(out), 1)
+		assertEqual(t, out[0]["level"], "INFO")
+		assertEqual(t, out[0]["msg"], "MW call DBGet succesfull")
+		assertEqual(t, out[0]["operation"], "DBGet")
+		assertEqual(t, out[0]["durationms"], 1.5)
+	})
+
+	t.Run("Logger : should filter on the level", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := New(&buf, "info")
+		l.Debug("hidden")
+		l.Trace("hidden")
+		l.Error("shown")
+		assertEqual(t, len(lines(t, &buf)), 1)
+		l.SetLevel("trace")
+		l.Trace("shown")
+		out := lines(t, &buf)
+		assertEqual(t, len(out), 2)
+		assertEqual(t, out[1]["level"], "TRACE")
+		assertEqual(t, l.Level(), "trace")
+	})
+
+	t.Run("ParseLevel : should default to info", func(t *testing.T) {
+		assertEqual(t, ParseLevel("nada"), ParseLevel("info"))
+		assertEqual(t, ParseLevel(" DEBUG "), ParseLevel("debug"))
+	})
+
+	t.Run("RequestID : should be carried by the ctx", func(t *testing.T) {
+		assertEqual(t, RequestID(context.Background()), "")
+		ctx := WithRequestID(context.Background(), "abc-123")
+		assertEqual(t, RequestID(ctx), "abc-123")
+	})
+
+	t.Run("NewRequestID : should be unique and valid", func(t *testing.T) {
+		a, b := NewRequestID(), NewRequestID()
+		assertEqual(t, len(a), 32)
+		assertEqual(t, a != b, true)
+		assertEqual(t, ValidRequestID(a), true)
+	})
+
+	t.Run("ValidRequestID : should reject empty, long or non printable ids", func(t *testing.T) {
+		assertEqual(t, ValidRequestID(""), false)
+		assertEqual(t, ValidRequestID(strings.Repeat("a", MAXREQUESTID+1)), false)
+		assertEqual(t, ValidRequestID("a b"), false)
+		assertEqual(t, ValidRequestID("a\nb"), false)
+		assertEqual(t, ValidRequestID("7f3c-req"), true)
+	})
+}
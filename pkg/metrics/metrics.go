@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const (
+	NAMESPACE string = "dbservice"
+	OK        string = "OK"
+	KO        string = "KO"
+)
+
+// request, database and cache metrics, all served on /metrics by the default prometheus registry
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "Requests handled per crudl operation and status code.",
+	}, []string{"crudl", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "Request latency per crudl operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"crudl"})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "mongodb_command_duration_seconds",
+		Help:      "MongoDB command latency per command and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "status"})
+
+	mongoPool = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "mongodb_pool_connections",
+		Help:      "MongoDB pool connections (open and in use).",
+	}, []string{"state"})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "redis_command_duration_seconds",
+		Help:      "Redis command latency per command and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "status"})
+
+	redisPoolOnce sync.Once
+)
+
+// status - private, the status label of a call
+func status(err error) string {
+	if err != nil {
+		return KO
+	}
+	return OK
+}
+
+// ObserveRequest - records a handled request, the error rate is the rate of the 4xx/5xx codes
+func ObserveRequest(crudl string, code int, start time.Time) {
+	requests.WithLabelValues(crudl, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(crudl).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRedis - records a redis call, a missing key (redis.Nil) is not an error
+func ObserveRedis(command string, start time.Time, err error) {
+	redisDuration.WithLabelValues(command, status(err)).Observe(time.Since(start).Seconds())
+}
+
+// CommandMonitor - records the latency of every command sent by the mongodb driver
+func CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			mongoDuration.WithLabelValues(e.CommandName, OK).Observe(e.Duration.Seconds())
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			mongoDuration.WithLabelValues(e.CommandName, KO).Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// PoolMonitor - tracks the open and in use connections of the mongodb driver pool
+func PoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{
+		Event: func(e *event.PoolEvent) {
+			switch e.Type {
+			case event.ConnectionCreated:
+				mongoPool.WithLabelValues("open").Inc()
+			case event.ConnectionClosed:
+				mongoPool.WithLabelValues("open").Dec()
+			case event.GetSucceeded:
+				mongoPool.WithLabelValues("in_use").Inc()
+			case event.ConnectionReturned:
+				mongoPool.WithLabelValues("in_use").Dec()
+			}
+		},
+	}
+}
+
+// RedisPool - exposes the redis pool stats, stats returns the total and idle connections
+// only the first call registers the gauges (there is one redis client per process)
+func RedisPool(stats func() (total uint32, idle uint32)) {
+	redisPoolOnce.Do(func() {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   NAMESPACE,
+			Name:        "redis_pool_connections",
+			Help:        "Redis pool connections.",
+			ConstLabels: prometheus.Labels{"state": "total"},
+		}, func() float64 {
+			total, _ := stats()
+			return float64(total)
+		})
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   NAMESPACE,
+			Name:        "redis_pool_connections",
+			Help:        "Redis pool connections.",
+			ConstLabels: prometheus.Labels{"state": "idle"},
+		}, func() float64 {
+			_, idle := stats()
+			return float64(idle)
+		})
+	})
+}
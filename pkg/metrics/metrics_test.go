@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+func assertEqual(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Fatalf("%v != %v", a, b)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+
+	t.Run("ObserveRequest : should count per crudl and code", func(t *testing.T) {
+		ObserveRequest("DBGet", 200, time.Now())
+		ObserveRequest("DBGet", 200, time.Now())
+		ObserveRequest("DBGet", 404, time.Now())
+		assertEqual(t, testutil.ToFloat64(requests.WithLabelValues("DBGet", "200")), float64(2))
+		assertEqual(t, testutil.ToFloat64(requests.WithLabelValues("DBGet", "404")), float64(1))
+		assertEqual(t, testutil.CollectAndCount(requestDuration, NAMESPACE+"_http_request_duration_seconds"), 1)
+	})
+
+	t.Run("ObserveRedis : should label the status", func(t *testing.T) {
+		ObserveRedis("get", time.Now(), nil)
+		ObserveRedis("get", time.Now(), errors.New("connection refused"))
+		assertEqual(t, testutil.CollectAndCount(redisDuration), 2)
+	})
+
+	t.Run("CommandMonitor : should observe the commands", func(t *testing.T) {
+		m := CommandMonitor()
+		m.Succeeded(context.Background(), &event.CommandSucceededEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "find", Duration: time.Millisecond}})
+		m.Failed(context.Background(), &event.CommandFailedEvent{CommandFinishedEvent: event.CommandFinishedEvent{CommandName: "insert", Duration: time.Millisecond}, Failure: "E11000"})
+		assertEqual(t, testutil.CollectAndCount(mongoDuration), 2)
+	})
+
+	t.Run("PoolMonitor : should track the connections", func(t *testing.T) {
+		m := PoolMonitor()
+		for _, e := range []string{event.ConnectionCreated, event.ConnectionCreated, event.GetSucceeded, event.ConnectionReturned, event.GetSucceeded, event.ConnectionClosed, event.PoolReady} {
+			m.Event(&event.PoolEvent{Type: e})
+		}
+		assertEqual(t, testutil.ToFloat64(mongoPool.WithLabelValues("open")), float64(1))
+		assertEqual(t, testutil.ToFloat64(mongoPool.WithLabelValues("in_use")), float64(1))
+	})
+
+	t.Run("RedisPool : should expose the pool stats", func(t *testing.T) {
+		RedisPool(func() (uint32, uint32) { return 10, 4 })
+		// a second client doesn't register the gauges again
+		RedisPool(func() (uint32, uint32) { return 0, 0 })
+		mfs, err := prometheus.DefaultGatherer.Gather()
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Test RedisPool gather returned with error - got (%v) wanted (%s)", err, "nil"))
+		}
+		found := 0
+		for _, mf := range mfs {
+			if mf.GetName() != NAMESPACE+"_redis_pool_connections" {
+				continue
+			}
+			for _, m := range mf.GetMetric() {
+				found++
+				if m.GetLabel()[0].GetValue() == "total" {
+					assertEqual(t, m.GetGauge().GetValue(), float64(10))
+				} else {
+					assertEqual(t, m.GetGauge().GetValue(), float64(4))
+				}
+			}
+		}
+		assertEqual(t, found, 2)
+	})
+}
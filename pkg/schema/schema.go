@@ -1,15 +1,20 @@
 package schema
 
 import (
-	"github.com/globalsign/mgo/bson"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // ShcemaInterface - acts as an interface wrapper
+// Custom holds the customer details, resources registered with a json schema keep their fields in Data
 type SchemaInterface struct {
-	ID         bson.ObjectId `json:"_id" bson:"_id,omitempty"`
-	LastUpdate int64         `json:"lastupdate,omitempty"`
-	MetaInfo   string        `json:"metainfo,omitempty"`
-	Custom     CustomDetail  `json:"custom,omitempty"`
+	ID         primitive.ObjectID     `json:"_id" bson:"_id,omitempty"`
+	LastUpdate int64                  `json:"lastupdate,omitempty"`
+	DeletedAt  int64                  `json:"deletedat,omitempty" bson:"deletedat,omitempty"`
+	MetaInfo   string                 `json:"metainfo,omitempty"`
+	Custom     CustomDetail           `json:"custom,omitempty" bson:"custom,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty" bson:"data,omitempty"`
 }
 
 // CustomDetail schema
@@ -22,11 +27,46 @@ type CustomDetail struct {
 	Address string `json:"address"`
 }
 
+// Resource - a named collection served under /api/v1/{resource}
+type Resource struct {
+	Name       string   `json:"name"`
+	Collection string   `json:"collection"`
+	Schema     string   `json:"schema,omitempty"` // json schema file used to validate request bodies
+	TextFields []string `json:"textfields,omitempty"`
+}
+
+// Policy - the role based access to the crudl operations, the roles are read from the RoleClaim
+// of the token (a dotted path i.e. realm_access.roles, default roles) or from the api key
+type Policy struct {
+	RoleClaim string            `json:"roleclaim,omitempty"`
+	Roles     map[string][]Rule `json:"roles"`
+	APIKeys   []APIKey          `json:"apikeys,omitempty"`
+}
+
+// Rule - the operations (i.e. DBGet, DBList) allowed on the resources, "*" matches any of them
+type Rule struct {
+	Operations []string `json:"operations"`
+	Resources  []string `json:"resources"`
+}
+
+// APIKey - a key sent in the X-API-Key header, only the hex sha256 of the key is kept
+type APIKey struct {
+	Name   string   `json:"name"`
+	SHA256 string   `json:"sha256"`
+	Roles  []string `json:"roles"`
+}
+
 // ListRange - used for pagination
+// To is the (exclusive) end index, if Cursor is set the page starts after that _id instead of skipping From documents
+// Email is an exact match on custom.email (it works when the email is encrypted)
+// Soft deleted documents are only listed with IncludeDeleted
 type ListRange struct {
-	From   int    `json:"From"`
-	To     int    `json:"to"`
-	Search string `json:"search,omitempty"`
+	From           int    `json:"From"`
+	To             int    `json:"to"`
+	Search         string `json:"search,omitempty"`
+	Cursor         string `json:"cursor,omitempty"`
+	Email          string `json:"email,omitempty"`
+	IncludeDeleted bool   `json:"includedeleted,omitempty"`
 }
 
 // Response schema
@@ -36,4 +76,113 @@ type Response struct {
 	Status     string            `json:"status"`
 	Message    string            `json:"message"`
 	Payload    []SchemaInterface `json:"payload"`
+	Total      int               `json:"total,omitempty"`
+	Next       string            `json:"next,omitempty"`
+	Errors     []FieldError      `json:"errors,omitempty"`
+	Results    []BulkResult      `json:"results,omitempty"`
+	RequestID  string            `json:"requestid,omitempty"`
+	History    []AuditEntry      `json:"history,omitempty"`
+}
+
+// FieldError - a field level validation error
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// BulkResult - the outcome of each item of a bulk request
+// Err is the item error returned by the connectors, the handler maps it to Code and Message
+type BulkResult struct {
+	Index   int    `json:"index"`
+	ID      string `json:"_id,omitempty"`
+	Code    int    `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Err     error  `json:"-"`
+}
+
+// AuditEntry - a mutation of a document, the actor is the subject of the caller token (or the api key name)
+// and Timestamp is in unix nanos like LastUpdate
+type AuditEntry struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Resource   string             `json:"resource"`
+	DocumentID string             `json:"documentid"`
+	Operation  string             `json:"operation"`
+	Actor      string             `json:"actor"`
+	RequestID  string             `json:"requestid,omitempty"`
+	Timestamp  int64              `json:"timestamp"`
+	Changes    []Change           `json:"changes,omitempty"`
+}
+
+// Change - the value of a field (dotted name i.e. custom.email) before and after a mutation
+type Change struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Webhook - the url the change events of the Resources are posted to, Events filters the event types (insert,
+// update, delete, restore), an empty list or "*" matches all of them. Secret is the key of the HMAC-SHA256 signature.
+// The events only carry the document (with the decrypted customer fields) if Document is set
+type Webhook struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret"`
+	Resources []string `json:"resources,omitempty"`
+	Events    []string `json:"events,omitempty"`
+	Document  bool     `json:"document,omitempty"`
+}
+
+// ChangeEvent - the change of a document posted to the webhooks, Document is the new document (not set
+// for the deletes and restores)
+type ChangeEvent struct {
+	ID         string           `json:"id"`
+	Type       string           `json:"type"`
+	Resource   string           `json:"resource"`
+	DocumentID string           `json:"documentid"`
+	Document   *SchemaInterface `json:"document,omitempty"`
+	Timestamp  int64            `json:"timestamp"`
+	RequestID  string           `json:"requestid,omitempty"`
+}
+
+// Delivery - the outcome (delivered or failed) of posting an event to a webhook, Code is the last http status
+type Delivery struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Webhook    string             `json:"webhook"`
+	URL        string             `json:"url"`
+	EventID    string             `json:"eventid"`
+	Type       string             `json:"type"`
+	Resource   string             `json:"resource"`
+	DocumentID string             `json:"documentid"`
+	Status     string             `json:"status"`
+	Code       int                `json:"code,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Attempts   int                `json:"attempts"`
+	Timestamp  int64              `json:"timestamp"`
+}
+
+// Alert - an alert fired by a trigger (errors, connection_lost, slow_query), the alerts with the same Key are
+// deduplicated and Count is the number of times it fired since it was last sent
+type Alert struct {
+	Trigger  string    `json:"trigger"`
+	Key      string    `json:"key"`
+	Severity string    `json:"severity"`
+	Message  string    `json:"message"`
+	Count    int       `json:"count"`
+	Time     time.Time `json:"time"`
+}
+
+// Readiness - the readiness probe response, Ready is false if any dependency is down
+type Readiness struct {
+	Ready        bool         `json:"ready"`
+	Version      string       `json:"version"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Dependency - the health of a backend (mongodb, redis) and the latency of its ping
+type Dependency struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyms"`
+	Message   string  `json:"message,omitempty"`
 }
@@ -0,0 +1,66 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"gitea-cicd.apps.aws2-dev.ocp.14west.io/cicd/golang-mongodbinterface/pkg/schema"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	ROOT     string = "(root)"
+	REQUIRED string = "required"
+)
+
+var (
+	schemas = map[string]*gojsonschema.Schema{}
+	slck    sync.RWMutex
+)
+
+// RegisterSchema : compiles the json schema file used to validate the request bodies of the named resource
+func RegisterSchema(name string, file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return fmt.Errorf("json schema %s : %v", file, err)
+	}
+	slck.Lock()
+	defer slck.Unlock()
+	schemas[name] = s
+	return nil
+}
+
+// ValidateDocument : checks a request body against the json schema of the named resource
+// partial (used for updates) ignores missing required fields as the body is merged with the stored document
+// It returns the field level errors, nil means the body is valid or no schema is registered for the resource
+func ValidateDocument(name string, body []byte, partial bool) []schema.FieldError {
+	slck.RLock()
+	s, ok := schemas[name]
+	slck.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	if !json.Valid(body) {
+		return []schema.FieldError{{Field: ROOT, Message: "invalid json"}}
+	}
+	result, err := s.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return []schema.FieldError{{Field: ROOT, Message: err.Error()}}
+	}
+
+	var errs []schema.FieldError
+	for _, e := range result.Errors() {
+		if partial && e.Type() == REQUIRED {
+			continue
+		}
+		errs = append(errs, schema.FieldError{Field: e.Field(), Message: e.Description()})
+	}
+	return errs
+}
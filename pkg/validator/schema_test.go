@@ -0,0 +1,69 @@
+package validator
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+
+	valid := []byte(`{"metainfo":"test","custom":{"name":"test","surname":"test","email":"test@test.com","mobile":"+353 1234567"}}`)
+
+	t.Run("RegisterSchema : should fail", func(t *testing.T) {
+		for _, file := range []string{"../../schemas/nada.json", "../../tests/config-parse-error.json"} {
+			err := RegisterSchema("test", file)
+			if err == nil {
+				t.Errorf(fmt.Sprintf("Handler %s returned with no error - got (%v) wanted (%s)", "RegisterSchema", err, "error"))
+			}
+		}
+	})
+
+	t.Run("ValidateDocument : no schema should pass", func(t *testing.T) {
+		errs := ValidateDocument("nada", []byte("{ "), false)
+		if errs != nil {
+			t.Errorf(fmt.Sprintf("Handler %s returned with errors - got (%v) wanted (%v)", "ValidateDocument", errs, nil))
+		}
+	})
+
+	t.Run("ValidateDocument : should pass", func(t *testing.T) {
+		err := RegisterSchema("customer", "../../schemas/customer.json")
+		if err != nil {
+			t.Fatalf(fmt.Sprintf("Handler %s returned with error - got (%v) wanted (%v)", "RegisterSchema", err, nil))
+		}
+		errs := ValidateDocument("customer", valid, false)
+		if errs != nil {
+			t.Errorf(fmt.Sprintf("Handler %s returned with errors - got (%v) wanted (%v)", "ValidateDocument", errs, nil))
+		}
+	})
+
+	t.Run("ValidateDocument : should report field errors", func(t *testing.T) {
+		body := []byte(`{"custom":{"name":"test","surname":"test","email":"","mobile":"garbage","nada":"test"}}`)
+		errs := ValidateDocument("customer", body, false)
+		fields := map[string]bool{}
+		for _, e := range errs {
+			fields[e.Field] = true
+		}
+		for _, field := range []string{"custom.email", "custom.mobile", "custom"} {
+			if !fields[field] {
+				t.Errorf(fmt.Sprintf("Handler %s missing field error - got (%v) wanted (%s)", "ValidateDocument", errs, field))
+			}
+		}
+	})
+
+	t.Run("ValidateDocument : invalid json should fail", func(t *testing.T) {
+		errs := ValidateDocument("customer", []byte("{ "), false)
+		if len(errs) != 1 || errs[0].Field != ROOT {
+			t.Errorf(fmt.Sprintf("Handler %s returned - got (%v) wanted (%s)", "ValidateDocument", errs, ROOT))
+		}
+	})
+
+	t.Run("ValidateDocument : partial should ignore required fields", func(t *testing.T) {
+		body := []byte(`{"_id":"5cc042307ccc69ada893144c","custom":{"email":"test@test.com"}}`)
+		if errs := ValidateDocument("customer", body, false); len(errs) == 0 {
+			t.Errorf(fmt.Sprintf("Handler %s returned no errors - got (%v) wanted (%s)", "ValidateDocument", errs, "errors"))
+		}
+		if errs := ValidateDocument("customer", body, true); errs != nil {
+			t.Errorf(fmt.Sprintf("Handler %s returned with errors - got (%v) wanted (%v)", "ValidateDocument", errs, nil))
+		}
+	})
+}